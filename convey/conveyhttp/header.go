@@ -1,7 +1,11 @@
 package conveyhttp
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"errors"
+	"io"
 	"net/http"
 
 	"github.com/xmidt-org/webpa-common/v2/convey"
@@ -10,6 +14,12 @@ import (
 // DefaultHeaderName is the HTTP header assumed to contain Convey data when no header is supplied
 const DefaultHeaderName = "X-Webpa-Convey"
 
+// MaxInflatedSize is the largest convey JSON, in bytes, that will be inflated from a gzip-compressed header
+const MaxInflatedSize = 64 * 1024
+
+// ErrInflatedTooLarge indicates that a gzip-compressed convey header inflated to more than MaxInflatedSize bytes
+var ErrInflatedTooLarge = errors.New("Compressed convey header is too large")
+
 // ErrMissingHeader indicates that no HTTP header exists which contains convey information
 var ErrMissingHeader = errors.New("No convey header present")
 
@@ -22,15 +32,32 @@ type HeaderTranslator interface {
 	ToHeader(http.Header, convey.C) error
 }
 
+// HeaderTranslatorOption configures optional behavior of a HeaderTranslator
+type HeaderTranslatorOption func(*headerTranslator)
+
+// WithGzip allows FromHeader to read convey headers whose JSON was gzip-compressed before being base64-encoded.
+// Compressed headers are detected automatically, and uncompressed headers are read as usual.  The encoding must be the
+// base64 encoding used by the convey.Translator.  If encoding is nil, base64.StdEncoding is used.  ToHeader never compresses.
+func WithGzip(encoding *base64.Encoding) HeaderTranslatorOption {
+	if encoding == nil {
+		encoding = base64.StdEncoding
+	}
+
+	return func(ht *headerTranslator) {
+		ht.gzipEncoding = encoding
+	}
+}
+
 // headerTranslator is the internal HeaderTranslator implementation
 type headerTranslator struct {
-	headerName string
-	translator convey.Translator
+	headerName   string
+	translator   convey.Translator
+	gzipEncoding *base64.Encoding
 }
 
 // NewHeaderTranslator creates a HeaderTranslator that uses a convey.Translator to produce
 // convey maps.
-func NewHeaderTranslator(headerName string, translator convey.Translator) HeaderTranslator {
+func NewHeaderTranslator(headerName string, translator convey.Translator, options ...HeaderTranslatorOption) HeaderTranslator {
 	if len(headerName) == 0 {
 		headerName = DefaultHeaderName
 	}
@@ -39,10 +66,42 @@ func NewHeaderTranslator(headerName string, translator convey.Translator) Header
 		translator = convey.NewTranslator(nil)
 	}
 
-	return &headerTranslator{
+	ht := &headerTranslator{
 		headerName: headerName,
 		translator: translator,
 	}
+
+	for _, o := range options {
+		o(ht)
+	}
+
+	return ht
+}
+
+// inflate returns the uncompressed form of a convey header value, if it was gzip-compressed.
+// Values that are not compressed are returned as is.
+func (ht *headerTranslator) inflate(v string) (string, error) {
+	raw, err := ht.gzipEncoding.DecodeString(v)
+	if err != nil || len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+		// not compressed, so let the convey.Translator deal with it
+		return v, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return "", convey.Error{Err: err, C: convey.Invalid}
+	}
+
+	defer reader.Close()
+	inflated, err := io.ReadAll(io.LimitReader(reader, MaxInflatedSize+1))
+	if err != nil {
+		return "", convey.Error{Err: err, C: convey.Invalid}
+	} else if len(inflated) > MaxInflatedSize {
+		return "", convey.Error{Err: ErrInflatedTooLarge, C: convey.Invalid}
+	}
+
+	// the convey.Translator expects base64, so hand it the inflated JSON in that form
+	return ht.gzipEncoding.EncodeToString(inflated), nil
 }
 
 func (ht *headerTranslator) FromHeader(h http.Header) (convey.C, error) {
@@ -53,6 +112,13 @@ func (ht *headerTranslator) FromHeader(h http.Header) (convey.C, error) {
 		return nil, convey.Error{ErrMissingHeader, convey.Missing}
 	}
 
+	if ht.gzipEncoding != nil {
+		var err error
+		if v, err = ht.inflate(v); err != nil {
+			return nil, err
+		}
+	}
+
 	return convey.ReadString(ht.translator, v)
 }
 
@@ -1,8 +1,11 @@
 package conveyhttp
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/base64"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -55,6 +58,71 @@ func testHeaderTranslatorToHeader(t *testing.T, actualHeaderName, expectedHeader
 	assert.NoError(err)
 }
 
+// gzipConvey produces a header value containing the given JSON, gzip-compressed and then base64-encoded
+func gzipConvey(t *testing.T, encoding *base64.Encoding, json string) string {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write([]byte(json))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	return encoding.EncodeToString(buffer.Bytes())
+}
+
+func testHeaderTranslatorFromHeaderGzip(t *testing.T, encoding *base64.Encoding) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		translator       = convey.NewTranslator(encoding)
+		headerTranslator = NewHeaderTranslator("", translator, WithGzip(encoding))
+		expected         = convey.C{"hw-model": "abc", "fw-name": "def"}
+	)
+
+	uncompressed, err := convey.WriteString(translator, expected)
+	require.NoError(err)
+
+	c, err := headerTranslator.FromHeader(http.Header{DefaultHeaderName: {uncompressed}})
+	assert.NoError(err)
+	assert.Equal(expected, c)
+
+	c, err = headerTranslator.FromHeader(http.Header{
+		DefaultHeaderName: {gzipConvey(t, encoding, `{"hw-model": "abc", "fw-name": "def"}`)},
+	})
+
+	assert.NoError(err)
+	assert.Equal(expected, c)
+
+	// without the option, compressed headers are invalid
+	c, err = NewHeaderTranslator("", translator).FromHeader(http.Header{
+		DefaultHeaderName: {gzipConvey(t, encoding, `{"hw-model": "abc", "fw-name": "def"}`)},
+	})
+
+	assert.Empty(c)
+	assert.Equal(convey.Invalid, convey.GetCompliance(err))
+}
+
+func testHeaderTranslatorFromHeaderGzipInvalid(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		headerTranslator = NewHeaderTranslator("", nil, WithGzip(nil))
+	)
+
+	// a gzip header followed by garbage
+	c, err := headerTranslator.FromHeader(http.Header{
+		DefaultHeaderName: {base64.StdEncoding.EncodeToString([]byte{0x1f, 0x8b, 0x00, 0x01, 0x02})},
+	})
+
+	assert.Empty(c)
+	assert.Equal(convey.Invalid, convey.GetCompliance(err))
+
+	c, err = headerTranslator.FromHeader(http.Header{
+		DefaultHeaderName: {gzipConvey(t, base64.StdEncoding, `{"big": "`+strings.Repeat("x", MaxInflatedSize)+`"}`)},
+	})
+
+	assert.Empty(c)
+	assert.Equal(convey.Error{Err: ErrInflatedTooLarge, C: convey.Invalid}, err)
+}
+
 func TestHeaderTranslator(t *testing.T) {
 	t.Run("FromHeader", func(t *testing.T) {
 		testHeaderTranslatorFromHeader(
@@ -90,6 +158,12 @@ func TestHeaderTranslator(t *testing.T) {
 		)
 	})
 
+	t.Run("FromHeaderGzip", func(t *testing.T) {
+		t.Run("StdEncoding", func(t *testing.T) { testHeaderTranslatorFromHeaderGzip(t, base64.StdEncoding) })
+		t.Run("RawURLEncoding", func(t *testing.T) { testHeaderTranslatorFromHeaderGzip(t, base64.RawURLEncoding) })
+		t.Run("Invalid", testHeaderTranslatorFromHeaderGzipInvalid)
+	})
+
 	t.Run("ToHeader", func(t *testing.T) {
 		testHeaderTranslatorToHeader(
 			t,
@@ -1,5 +1,20 @@
 package device
 
+import (
+	"errors"
+	"net"
+)
+
+// IdleTimeoutReason is the CloseReason text used when a device is disconnected because no
+// traffic arrived from it within the idle period.
+const IdleTimeoutReason = "idle-timeout"
+
+// isTimeout tests if err indicates that a connection deadline expired
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // CloseReason exposes metadata around why a particular device was closed
 type CloseReason struct {
 	// Err is the optional field that specifies the underlying error that occurred, such as
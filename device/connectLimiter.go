@@ -0,0 +1,62 @@
+package device
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// connectLimiter is a token bucket that limits the rate of device connections
+type connectLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newConnectLimiter creates a connectLimiter that allows rate connections per second with the given burst.
+// If rate is nonpositive, this function returns nil, which allows every connection.  If burst is nonpositive,
+// a burst of the rate rounded up to the nearest whole connection is used.
+func newConnectLimiter(rate float64, burst int, now func() time.Time) *connectLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+
+	return &connectLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now(),
+		now:    now,
+	}
+}
+
+// allow takes a token from the bucket, returning false if no token is available.  A nil
+// connectLimiter allows every connection.
+func (cl *connectLimiter) allow() bool {
+	if cl == nil {
+		return true
+	}
+
+	defer cl.lock.Unlock()
+	cl.lock.Lock()
+
+	now := cl.now()
+	if elapsed := now.Sub(cl.last); elapsed > 0 {
+		cl.tokens = math.Min(cl.burst, cl.tokens+elapsed.Seconds()*cl.rate)
+	}
+
+	cl.last = now
+	if cl.tokens < 1 {
+		return false
+	}
+
+	cl.tokens--
+	return true
+}
@@ -0,0 +1,52 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectLimiter(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		var cl *connectLimiter
+		assert.True(t, cl.allow())
+		assert.Nil(t, newConnectLimiter(0.0, 10, time.Now))
+	})
+
+	t.Run("DefaultBurst", func(t *testing.T) {
+		cl := newConnectLimiter(2.5, 0, time.Now)
+		require.NotNil(t, cl)
+		assert.Equal(t, 3.0, cl.burst)
+	})
+
+	t.Run("Refill", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+
+			now = time.Now()
+			cl  = newConnectLimiter(2.0, 3, func() time.Time { return now })
+		)
+
+		require.NotNil(cl)
+		for i := 0; i < 3; i++ {
+			assert.True(cl.allow())
+		}
+
+		assert.False(cl.allow())
+
+		now = now.Add(500 * time.Millisecond)
+		assert.True(cl.allow())
+		assert.False(cl.allow())
+
+		// the bucket never refills past the burst
+		now = now.Add(time.Hour)
+		for i := 0; i < 3; i++ {
+			assert.True(cl.allow())
+		}
+
+		assert.False(cl.allow())
+	})
+}
@@ -110,9 +110,17 @@ type device struct {
 
 	state int32
 
-	shutdown     chan struct{}
-	messages     chan *envelope
-	transactions *Transactions
+	shutdown       chan struct{}
+	messages       chan *envelope
+	overflowPolicy QueueOverflowPolicy
+	dropped        func(*device, *envelope)
+	transactions   *Transactions
+
+	// highWater is the queue depth that triggers a QueueHighWater event, and is disabled when nonpositive.
+	// aboveHighWater is set atomically, so that each crossing is reported exactly once.
+	highWater      int
+	aboveHighWater int32
+	queueCrossed   func(*device, EventType, int)
 
 	c             convey.Interface
 	compliance    convey.Compliance
@@ -120,17 +128,33 @@ type device struct {
 
 	metadata *Metadata
 
+	// enforceDestination rejects requests whose destination is not this device
+	enforceDestination bool
+
 	closeReason atomic.Value
 }
 
 type deviceOptions struct {
-	ID          ID
-	C           convey.Interface
-	Compliance  convey.Compliance
-	QueueSize   int
-	ConnectedAt time.Time
-	Logger      *zap.Logger
-	Metadata    *Metadata
+	ID                  ID
+	C                   convey.Interface
+	Compliance          convey.Compliance
+	QueueSize           int
+	QueueOverflowPolicy QueueOverflowPolicy
+	ConnectedAt         time.Time
+	Logger              *zap.Logger
+	Metadata            *Metadata
+
+	// Dropped is invoked for each message discarded from the queue under QueueOverflowDropOldest
+	Dropped func(*device, *envelope)
+
+	// EnforceDestination causes Send to reject requests that are not addressed to this device
+	EnforceDestination bool
+
+	// QueueHighWater is the queue depth at which QueueCrossed is invoked with a QueueHighWater event
+	QueueHighWater int
+
+	// QueueCrossed is invoked when the queue reaches QueueHighWater and when it later recedes
+	QueueCrossed func(*device, EventType, int)
 }
 
 // newDevice is an internal factory function for devices
@@ -147,17 +171,36 @@ func newDevice(o deviceOptions) *device {
 		o.QueueSize = DefaultDeviceMessageQueueSize
 	}
 
+	if len(o.QueueOverflowPolicy) == 0 {
+		o.QueueOverflowPolicy = QueueOverflowBlock
+	}
+
+	if o.Dropped == nil {
+		o.Dropped = func(*device, *envelope) {}
+	}
+
+	if o.QueueCrossed == nil {
+		o.QueueCrossed = func(*device, EventType, int) {}
+	}
+
 	return &device{
-		id:           o.ID,
-		logger:       o.Logger.With(zap.String("id", string(o.ID))),
-		statistics:   NewStatistics(nil, o.ConnectedAt),
-		c:            o.C,
-		compliance:   o.Compliance,
-		state:        stateOpen,
-		shutdown:     make(chan struct{}),
-		messages:     make(chan *envelope, o.QueueSize),
-		transactions: NewTransactions(),
-		metadata:     o.Metadata,
+		id:             o.ID,
+		logger:         o.Logger.With(zap.String("id", string(o.ID))),
+		statistics:     NewStatistics(nil, o.ConnectedAt),
+		c:              o.C,
+		compliance:     o.Compliance,
+		state:          stateOpen,
+		shutdown:       make(chan struct{}),
+		messages:       make(chan *envelope, o.QueueSize),
+		overflowPolicy: o.QueueOverflowPolicy,
+		dropped:        o.Dropped,
+		conveyClosure:  func() {},
+		transactions:   NewTransactions(),
+		metadata:       o.Metadata,
+
+		enforceDestination: o.EnforceDestination,
+		highWater:          o.QueueHighWater,
+		queueCrossed:       o.QueueCrossed,
 	}
 }
 
@@ -222,14 +265,12 @@ func (d *device) sendRequest(request *Request) error {
 	)
 
 	// attempt to enqueue the message
-	select {
-	case <-done:
-		return request.Context().Err()
-	case <-d.shutdown:
-		return ErrorDeviceClosed
-	case d.messages <- envelope:
+	if err := d.enqueue(request, envelope); err != nil {
+		return err
 	}
 
+	d.checkQueueDepth()
+
 	// once enqueued, wait until the context is cancelled
 	// or there's a result
 	select {
@@ -242,6 +283,86 @@ func (d *device) sendRequest(request *Request) error {
 	}
 }
 
+// enqueue places the envelope on this device's message queue, applying the configured overflow policy
+// when the queue is full.  The request context's cancellation semantics are honored for each policy.
+func (d *device) enqueue(request *Request, e *envelope) error {
+	done := request.Context().Done()
+	switch d.overflowPolicy {
+	case QueueOverflowDropNewest:
+		select {
+		case <-done:
+			return request.Context().Err()
+		case <-d.shutdown:
+			return ErrorDeviceClosed
+		case d.messages <- e:
+			return nil
+		default:
+			return ErrorDeviceQueueFull
+		}
+
+	case QueueOverflowDropOldest:
+		for {
+			select {
+			case <-done:
+				return request.Context().Err()
+			case <-d.shutdown:
+				return ErrorDeviceClosed
+			case d.messages <- e:
+				return nil
+			default:
+			}
+
+			// the queue was full, so discard the oldest message if the write pump hasn't already taken it
+			select {
+			case oldest := <-d.messages:
+				oldest.complete <- ErrorDeviceQueueFull
+				close(oldest.complete)
+				d.dropped(d, oldest)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case <-done:
+			return request.Context().Err()
+		case <-d.shutdown:
+			return ErrorDeviceClosed
+		case d.messages <- e:
+			return nil
+		}
+	}
+}
+
+// checkQueueDepth reports the queue crossing the high-water mark in either direction.  This method
+// is invoked after each message is enqueued and by the write pump after each message is dequeued.
+//
+// Only the goroutine whose CompareAndSwap flips aboveHighWater reports a crossing, so no crossing is
+// reported twice.  Because the queue can change after its depth is read, the depth is checked again
+// after each crossing, so that a crossing reported from a stale depth is followed by its reversal.
+func (d *device) checkQueueDepth() {
+	if d.highWater < 1 {
+		return
+	}
+
+	for {
+		depth := len(d.messages)
+		if depth >= d.highWater {
+			if !atomic.CompareAndSwapInt32(&d.aboveHighWater, 0, 1) {
+				return
+			}
+
+			d.queueCrossed(d, QueueHighWater, depth)
+		} else {
+			if !atomic.CompareAndSwapInt32(&d.aboveHighWater, 1, 0) {
+				return
+			}
+
+			d.queueCrossed(d, QueueRecovered, depth)
+		}
+	}
+}
+
 // awaitResponse waits for the read pump to acquire a response that corresponds to the
 // request's transaction key.  The result channel will receive the response from the
 // read pump.
@@ -260,11 +381,34 @@ func (d *device) awaitResponse(request *Request, result <-chan *Response) (*Resp
 	}
 }
 
+// checkDestination verifies that a request is addressed to this device.  A destination
+// that cannot be parsed is treated as a mismatch.
+func (d *device) checkDestination(request *Request) error {
+	destination, err := request.ID()
+	if err != nil {
+		d.logger.Error("rejecting message with an invalid destination", zap.Error(err))
+		return ErrorDestinationMismatch
+	}
+
+	if destination != d.id {
+		d.logger.Error("rejecting misrouted message", zap.String("destination", string(destination)))
+		return ErrorDestinationMismatch
+	}
+
+	return nil
+}
+
 func (d *device) Send(request *Request) (*Response, error) {
 	if d.Closed() {
 		return nil, ErrorDeviceClosed
 	}
 
+	if d.enforceDestination {
+		if err := d.checkDestination(request); err != nil {
+			return nil, err
+		}
+	}
+
 	var (
 		transactionKey, transactional = request.Transactional()
 		result                        <-chan *Response
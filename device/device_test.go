@@ -3,6 +3,8 @@ package device
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -107,3 +109,380 @@ func TestDevice(t *testing.T) {
 		assert.Error(err)
 	}
 }
+
+// newOverflowTestDevice creates a device with a queue of size 1 that already holds a pending message
+func newOverflowTestDevice(t *testing.T, policy QueueOverflowPolicy) (*device, <-chan error, chan *envelope) {
+	var (
+		require = require.New(t)
+		dropped = make(chan *envelope, 1)
+		first   = make(chan error, 1)
+
+		d = newDevice(deviceOptions{
+			ID:                  ID("mac:112233445566"),
+			QueueSize:           1,
+			QueueOverflowPolicy: policy,
+			Logger:              sallust.Default(),
+			Metadata:            new(Metadata),
+			Dropped: func(_ *device, e *envelope) {
+				dropped <- e
+			},
+		})
+	)
+
+	go func() {
+		// nolint: typecheck
+		_, err := d.Send(&Request{Message: new(wrp.Message)})
+		first <- err
+	}()
+
+	require.Eventually(
+		func() bool { return d.Pending() == 1 },
+		5*time.Second,
+		10*time.Millisecond,
+		"the first message was never enqueued",
+	)
+
+	return d, first, dropped
+}
+
+func testDeviceQueueOverflowBlock(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		d, first, _    = newOverflowTestDevice(t, QueueOverflowBlock)
+		ctx, cancel    = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		secondResponse *Response
+		secondErr      error
+	)
+
+	defer cancel()
+	// nolint: typecheck
+	secondResponse, secondErr = d.Send((&Request{Message: new(wrp.Message)}).WithContext(ctx))
+	assert.Nil(secondResponse)
+	assert.Equal(context.DeadlineExceeded, secondErr)
+	assert.Equal(1, d.Pending())
+
+	d.requestClose(CloseReason{Text: "test"})
+	assert.Equal(ErrorDeviceClosed, <-first)
+}
+
+func testDeviceQueueOverflowDropNewest(t *testing.T) {
+	var (
+		assert            = assert.New(t)
+		d, first, dropped = newOverflowTestDevice(t, QueueOverflowDropNewest)
+	)
+
+	// nolint: typecheck
+	response, err := d.Send(&Request{Message: new(wrp.Message)})
+	assert.Nil(response)
+	assert.Equal(ErrorDeviceQueueFull, err)
+	assert.Equal(1, d.Pending())
+	assert.Empty(dropped)
+
+	d.requestClose(CloseReason{Text: "test"})
+	assert.Equal(ErrorDeviceClosed, <-first)
+}
+
+func testDeviceQueueOverflowDropOldest(t *testing.T) {
+	var (
+		assert            = assert.New(t)
+		require           = require.New(t)
+		d, first, dropped = newOverflowTestDevice(t, QueueOverflowDropOldest)
+		ctx, cancel       = context.WithCancel(context.Background())
+		second            = make(chan error, 1)
+		// nolint: typecheck
+		secondRequest = (&Request{Message: new(wrp.Message)}).WithContext(ctx)
+	)
+
+	go func() {
+		_, err := d.Send(secondRequest)
+		second <- err
+	}()
+
+	select {
+	case err := <-first:
+		assert.Equal(ErrorDeviceQueueFull, err)
+	case <-time.After(5 * time.Second):
+		require.Fail("the oldest message was not dropped")
+	}
+
+	select {
+	case e := <-dropped:
+		assert.NotEqual(secondRequest, e.request)
+	case <-time.After(5 * time.Second):
+		require.Fail("the dropped callback was not invoked")
+	}
+
+	require.Equal(1, d.Pending())
+	queued := <-d.messages
+	assert.Equal(secondRequest, queued.request)
+
+	cancel()
+	assert.Equal(context.Canceled, <-second)
+}
+
+func TestDeviceQueueOverflow(t *testing.T) {
+	t.Run("Block", testDeviceQueueOverflowBlock)
+	t.Run("DropNewest", testDeviceQueueOverflowDropNewest)
+	t.Run("DropOldest", testDeviceQueueOverflowDropOldest)
+}
+
+func testDeviceEnforceDestinationMatch(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		d       = newDevice(deviceOptions{
+			ID:                 ID("mac:112233445566"),
+			Logger:             sallust.Default(),
+			EnforceDestination: true,
+		})
+
+		result = make(chan error, 1)
+	)
+
+	go func() {
+		// nolint: typecheck
+		_, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:112233445566/service"}})
+		result <- err
+	}()
+
+	select {
+	case e := <-d.messages:
+		e.complete <- nil
+	case <-time.After(5 * time.Second):
+		require.Fail("the message was never enqueued")
+	}
+
+	assert.NoError(<-result)
+}
+
+func testDeviceEnforceDestinationMismatch(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(deviceOptions{
+			ID:                 ID("mac:112233445566"),
+			Logger:             sallust.Default(),
+			EnforceDestination: true,
+		})
+	)
+
+	// nolint: typecheck
+	response, err := d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:665544332211/service"}})
+	assert.Nil(response)
+	assert.Equal(ErrorDestinationMismatch, err)
+
+	// a request without a destination cannot be verified
+	response, err = d.Send(&Request{Contents: []byte("raw")})
+	assert.Nil(response)
+	assert.Equal(ErrorDestinationMismatch, err)
+
+	// nolint: typecheck
+	response, err = d.Send(&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "invalid"}})
+	assert.Nil(response)
+	assert.Equal(ErrorDestinationMismatch, err)
+
+	assert.Zero(d.Pending())
+}
+
+func testDeviceEnforceDestinationDisabled(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		d      = newDevice(deviceOptions{
+			ID:        ID("mac:112233445566"),
+			QueueSize: 1,
+			Logger:    sallust.Default(),
+		})
+
+		ctx, cancel = context.WithCancel(context.Background())
+		result      = make(chan error, 1)
+	)
+
+	go func() {
+		// nolint: typecheck
+		_, err := d.Send((&Request{Message: &wrp.Message{Type: wrp.SimpleEventMessageType, Destination: "mac:665544332211/service"}}).WithContext(ctx))
+		result <- err
+	}()
+
+	assert.Eventually(
+		func() bool { return d.Pending() == 1 },
+		5*time.Second,
+		10*time.Millisecond,
+		"a mismatched destination should be enqueued when the check is disabled",
+	)
+
+	cancel()
+	assert.Equal(context.Canceled, <-result)
+}
+
+func TestDeviceEnforceDestination(t *testing.T) {
+	t.Run("Match", testDeviceEnforceDestinationMatch)
+	t.Run("Mismatch", testDeviceEnforceDestinationMismatch)
+	t.Run("Disabled", testDeviceEnforceDestinationDisabled)
+}
+
+func testDeviceQueueHighWater(t *testing.T) {
+	type crossing struct {
+		eventType EventType
+		depth     int
+	}
+
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		crossings = make(chan crossing, 10)
+
+		d = newDevice(deviceOptions{
+			ID:             ID("mac:112233445566"),
+			QueueSize:      4,
+			QueueHighWater: 2,
+			Logger:         sallust.Default(),
+			QueueCrossed: func(_ *device, eventType EventType, depth int) {
+				crossings <- crossing{eventType, depth}
+			},
+		})
+
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+
+	defer cancel()
+
+	send := func(expectedDepth int) {
+		go func() {
+			// nolint: typecheck
+			d.Send((&Request{Message: new(wrp.Message)}).WithContext(ctx))
+		}()
+
+		require.Eventually(
+			func() bool { return d.Pending() == expectedDepth },
+			5*time.Second,
+			10*time.Millisecond,
+		)
+	}
+
+	// mimics the write pump
+	receive := func() {
+		<-d.messages
+		d.checkQueueDepth()
+	}
+
+	expectNone := func() {
+		select {
+		case c := <-crossings:
+			assert.Fail("unexpected queue crossing", "%s at depth %d", c.eventType, c.depth)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	send(1)
+	expectNone()
+
+	send(2)
+	assert.Equal(crossing{QueueHighWater, 2}, <-crossings)
+
+	send(3)
+	expectNone()
+
+	receive()
+	expectNone()
+
+	receive()
+	assert.Equal(crossing{QueueRecovered, 1}, <-crossings)
+
+	receive()
+	expectNone()
+
+	send(1)
+	send(2)
+	assert.Equal(crossing{QueueHighWater, 2}, <-crossings)
+	expectNone()
+}
+
+func testDeviceQueueDepthStaleCrossing(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		crossings []EventType
+
+		d = newDevice(deviceOptions{
+			ID:             ID("mac:112233445566"),
+			QueueSize:      4,
+			QueueHighWater: 2,
+			Logger:         sallust.Default(),
+			QueueCrossed: func(_ *device, eventType EventType, _ int) {
+				crossings = append(crossings, eventType)
+			},
+		})
+	)
+
+	// the flag is flipped from a depth that is stale by the time the crossing is reported,
+	// so the queue is already below the high-water mark.  the crossing must be reversed.
+	d.messages <- new(envelope)
+	atomic.StoreInt32(&d.aboveHighWater, 1)
+	d.checkQueueDepth()
+	assert.Equal([]EventType{QueueRecovered}, crossings)
+	assert.Zero(atomic.LoadInt32(&d.aboveHighWater))
+
+	d.checkQueueDepth()
+	assert.Equal([]EventType{QueueRecovered}, crossings, "a crossing should not be reported twice")
+}
+
+func testDeviceQueueDepthConcurrent(t *testing.T) {
+	const rounds = 1000
+
+	var (
+		assert = assert.New(t)
+
+		lock      sync.Mutex
+		crossings []EventType
+
+		d = newDevice(deviceOptions{
+			ID:             ID("mac:112233445566"),
+			QueueSize:      rounds,
+			QueueHighWater: 2,
+			Logger:         sallust.Default(),
+			QueueCrossed: func(_ *device, eventType EventType, _ int) {
+				defer lock.Unlock()
+				lock.Lock()
+				crossings = append(crossings, eventType)
+			},
+		})
+
+		waitGroup sync.WaitGroup
+	)
+
+	waitGroup.Add(2)
+	go func() {
+		defer waitGroup.Done()
+		for i := 0; i < rounds; i++ {
+			d.messages <- new(envelope)
+			d.checkQueueDepth()
+		}
+	}()
+
+	go func() {
+		defer waitGroup.Done()
+		for i := 0; i < rounds; i++ {
+			<-d.messages
+			d.checkQueueDepth()
+		}
+	}()
+
+	waitGroup.Wait()
+
+	highWater, recovered := 0, 0
+	for _, eventType := range crossings {
+		if eventType == QueueHighWater {
+			highWater++
+		} else {
+			recovered++
+		}
+	}
+
+	// the queue ends empty, so every high-water crossing must have been matched by a recovery
+	assert.Equal(highWater, recovered)
+	assert.Zero(atomic.LoadInt32(&d.aboveHighWater))
+}
+
+func TestDeviceQueueDepth(t *testing.T) {
+	t.Run("HighWater", testDeviceQueueHighWater)
+	t.Run("StaleCrossing", testDeviceQueueDepthStaleCrossing)
+	t.Run("Concurrent", testDeviceQueueDepthConcurrent)
+}
@@ -0,0 +1,74 @@
+package devicegate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// CIDRFilterSet is a PatternSet whose values are CIDR blocks.  A device value matches if
+// it is an IP address, either a net.IP or a string, contained in any of the blocks.
+type CIDRFilterSet struct {
+	blocks   []string
+	networks []*net.IPNet
+}
+
+// NewCIDRFilterSet parses each block, e.g. "10.0.0.0/8" or "2001:db8::/32", into a CIDRFilterSet.
+// An error is returned if any block is not valid CIDR notation.
+func NewCIDRFilterSet(blocks ...string) (*CIDRFilterSet, error) {
+	cs := &CIDRFilterSet{
+		blocks:   make([]string, 0, len(blocks)),
+		networks: make([]*net.IPNet, 0, len(blocks)),
+	}
+
+	for _, b := range blocks {
+		_, network, err := net.ParseCIDR(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter CIDR block %q: %w", b, err)
+		}
+
+		cs.blocks = append(cs.blocks, b)
+		cs.networks = append(cs.networks, network)
+	}
+
+	return cs, nil
+}
+
+// Match returns the first block that contains the value
+func (cs *CIDRFilterSet) Match(value interface{}) (string, bool) {
+	var ip net.IP
+	switch v := value.(type) {
+	case net.IP:
+		ip = v
+	case string:
+		ip = net.ParseIP(v)
+	}
+
+	if ip != nil {
+		for i, network := range cs.networks {
+			if network.Contains(ip) {
+				return cs.blocks[i], true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Has returns true if any block contains the value
+func (cs *CIDRFilterSet) Has(value interface{}) bool {
+	_, matched := cs.Match(value)
+	return matched
+}
+
+// VisitAll applies the visitor function to each block
+func (cs *CIDRFilterSet) VisitAll(f func(interface{})) {
+	for _, b := range cs.blocks {
+		f(b)
+	}
+}
+
+// MarshalJSON writes the blocks as a JSON array
+func (cs *CIDRFilterSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cs.blocks)
+}
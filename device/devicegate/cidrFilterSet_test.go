@@ -0,0 +1,113 @@
+package devicegate
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/webpa-common/v2/device"
+)
+
+func TestNewCIDRFilterSet(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+		)
+
+		cs, err := NewCIDRFilterSet("10.0.0.0/8", "2001:db8::/32")
+		require.NoError(err)
+		require.NotNil(cs)
+
+		var visited []interface{}
+		cs.VisitAll(func(v interface{}) { visited = append(visited, v) })
+		assert.Equal([]interface{}{"10.0.0.0/8", "2001:db8::/32"}, visited)
+
+		data, err := json.Marshal(cs)
+		require.NoError(err)
+		assert.JSONEq(`["10.0.0.0/8", "2001:db8::/32"]`, string(data))
+	})
+
+	for _, invalid := range []string{"10.0.0.0", "10.0.0.0/33", "not a block"} {
+		t.Run("Invalid", func(t *testing.T) {
+			cs, err := NewCIDRFilterSet("192.168.0.0/16", invalid)
+			assert.Nil(t, cs)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCIDRFilterSetMatch(t *testing.T) {
+	cs, err := NewCIDRFilterSet("10.0.0.0/8", "2001:db8::/32")
+	require.NoError(t, err)
+
+	tests := []struct {
+		value           interface{}
+		expectedPattern string
+		expectedMatch   bool
+	}{
+		{value: "10.1.2.3", expectedPattern: "10.0.0.0/8", expectedMatch: true},
+		{value: net.ParseIP("10.255.255.255"), expectedPattern: "10.0.0.0/8", expectedMatch: true},
+		{value: "11.1.2.3"},
+		{value: "2001:db8::1", expectedPattern: "2001:db8::/32", expectedMatch: true},
+		{value: "2001:db9::1"},
+		{value: "not an address"},
+		{value: 10},
+	}
+
+	for _, tc := range tests {
+		pattern, matched := cs.Match(tc.value)
+		assert.Equal(t, tc.expectedMatch, matched, tc.value)
+		assert.Equal(t, tc.expectedPattern, pattern, tc.value)
+		assert.Equal(t, tc.expectedMatch, cs.Has(tc.value), tc.value)
+	}
+}
+
+func TestFilterGateAllowConnectionCIDR(t *testing.T) {
+	cs, err := NewCIDRFilterSet("192.168.0.0/16", "fd00::/8")
+	require.NoError(t, err)
+
+	fg := FilterGate{
+		FilterStore: FilterStore{"client-ip": cs},
+	}
+
+	tests := []struct {
+		ip             string
+		canPass        bool
+		expectedResult device.MatchResult
+	}{
+		{
+			ip:             "192.168.10.20",
+			expectedResult: device.MatchResult{Location: metadataMapLocation, Key: "client-ip", Pattern: "192.168.0.0/16"},
+		},
+		{
+			ip:      "172.16.0.1",
+			canPass: true,
+		},
+		{
+			ip:             "fd12:3456::1",
+			expectedResult: device.MatchResult{Location: metadataMapLocation, Key: "client-ip", Pattern: "fd00::/8"},
+		},
+		{
+			ip:      "2001:db8::1",
+			canPass: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ip, func(t *testing.T) {
+			metadata := new(device.Metadata)
+			metadata.Store("client-ip", tc.ip)
+
+			mockDevice := new(device.MockDevice)
+			// nolint: typecheck
+			mockDevice.On("Metadata").Return(metadata)
+
+			canPass, result := fg.AllowConnection(mockDevice)
+			assert.Equal(t, tc.canPass, canPass)
+			assert.Equal(t, tc.expectedResult, result)
+		})
+	}
+}
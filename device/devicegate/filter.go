@@ -2,7 +2,10 @@ package devicegate
 
 import (
 	"encoding/json"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/xmidt-org/webpa-common/v2/device"
 )
@@ -10,6 +13,34 @@ import (
 const (
 	metadataMapLocation = "metadata_map"
 	claimsLocation      = "claims"
+
+	// compositeSeparator joins the keys and locations of each match in the composite MatchResult of an AND gate
+	compositeSeparator = ","
+)
+
+// MatchMode determines how a FilterGate combines the matches of its filter keys
+type MatchMode string
+
+const (
+	// MatchModeOr filters a device if any filter key matches.  This is the default.
+	MatchModeOr MatchMode = "or"
+
+	// MatchModeAnd filters a device only if every filter key matches
+	MatchModeAnd MatchMode = "and"
+)
+
+// FilterType is the kind of filter values in a FilterRequest
+type FilterType string
+
+const (
+	// FilterTypeExact filters devices whose values exactly match a filter value.  This is the default.
+	FilterTypeExact FilterType = "exact"
+
+	// FilterTypeRegex filters devices whose values match a regular expression.  See NewRegexFilterSet.
+	FilterTypeRegex FilterType = "regex"
+
+	// FilterTypeCIDR filters devices whose IP addresses are contained in a CIDR block.  See NewCIDRFilterSet.
+	FilterTypeCIDR FilterType = "cidr"
 )
 
 // Interface is a gate interface specifically for filtering devices
@@ -30,6 +61,13 @@ type Interface interface {
 	// bool that is true if the filter key did not previously exist and false if the filter key had existed beforehand.
 	SetFilter(key string, values []interface{}) (Set, bool)
 
+	// SetPatternFilter is like SetFilter, except that device values are matched against the patterns
+	// in the given PatternSet, such as a RegexFilterSet or a CIDRFilterSet.
+	SetPatternFilter(key string, patterns PatternSet) (Set, bool)
+
+	// SetFilterExpiring is like SetFilter, except that the filter values stop filtering devices at the given time.
+	SetFilterExpiring(key string, values []interface{}, expires time.Time) (Set, bool)
+
 	// DeleteFilter deletes a filter key. This completely removes all filter values associated with that key as well.
 	// Returns true if key had existed and values actually deleted, and false if key was not found.
 	DeleteFilter(key string) bool
@@ -49,12 +87,30 @@ type Set interface {
 	VisitAll(func(interface{}))
 }
 
+// PatternSet is a Set whose values are patterns that device values are matched against,
+// as opposed to values that must be matched exactly.
+type PatternSet interface {
+	Set
+
+	// Match returns the pattern that matched the given value and true, or the empty string and
+	// false if no pattern matched.
+	Match(interface{}) (string, bool)
+}
+
 // FilterStore can be used to store filters in the Interface
 type FilterStore map[string]Set
 
 // FilterSet is a concrete type that implements the Set interface
 type FilterSet struct {
-	Set  map[interface{}]bool
+	Set map[interface{}]bool
+
+	// Expires holds optional expiration times for values in Set.  A value without an expiration
+	// never expires.  Expired values are ignored by all Set methods and are removed by FilterGate.Sweep.
+	Expires map[interface{}]time.Time
+
+	// Now is the clock used to check expirations.  If nil, time.Now is used.
+	Now func() time.Time
+
 	lock sync.RWMutex
 }
 
@@ -63,12 +119,25 @@ type FilterGate struct {
 	FilterStore    FilterStore `json:"filters"`
 	AllowedFilters Set         `json:"allowedFilters"`
 
+	// Mode is how matches across filter keys are combined.  If unset, MatchModeOr is used.
+	Mode MatchMode `json:"mode,omitempty"`
+
+	// Now is the clock given to filter sets created by SetFilterExpiring.  If nil, time.Now is used.
+	Now func() time.Time `json:"-"`
+
 	lock sync.RWMutex
 }
 
 type FilterRequest struct {
 	Key    string        `json:"key"`
 	Values []interface{} `json:"values"`
+
+	// Type is the kind of filter values.  If unset, FilterTypeExact is used.
+	Type FilterType `json:"type,omitempty"`
+
+	// TTL is how long the filter values remain in effect, in time.ParseDuration format, e.g. "30m".
+	// If unset, the values never expire.  Only exact filter values can expire.
+	TTL string `json:"ttl,omitempty"`
 }
 
 func (f *FilterGate) VisitAll(visit func(string, Set) bool) int {
@@ -118,6 +187,86 @@ func (f *FilterGate) SetFilter(key string, values []interface{}) (Set, bool) {
 
 }
 
+// SetPatternFilter is like SetFilter, except that device values are matched against the given patterns
+func (f *FilterGate) SetPatternFilter(key string, patterns PatternSet) (Set, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	oldValues := f.FilterStore[key]
+	f.FilterStore[key] = patterns
+	return oldValues, oldValues == nil
+}
+
+// SetFilterExpiring is like SetFilter, except that the filter values expire at the given time.
+// Once expired, the values no longer filter devices and are pruned by Sweep.
+func (f *FilterGate) SetFilterExpiring(key string, values []interface{}, expires time.Time) (Set, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	oldValues := f.FilterStore[key]
+	newValues := make(map[interface{}]bool, len(values))
+	expirations := make(map[interface{}]time.Time, len(values))
+
+	for _, v := range values {
+		newValues[v] = true
+		expirations[v] = expires
+	}
+
+	f.FilterStore[key] = &FilterSet{
+		Set:     newValues,
+		Expires: expirations,
+		Now:     f.Now,
+	}
+
+	return oldValues, oldValues == nil
+}
+
+// Sweep removes expired values from every FilterSet in this gate, deleting any filter key
+// that is left with no values.  The number of values removed is returned.
+func (f *FilterGate) Sweep() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	removed := 0
+	for key, values := range f.FilterStore {
+		if fs, ok := values.(*FilterSet); ok {
+			pruned, empty := fs.prune()
+			removed += pruned
+			if pruned > 0 && empty {
+				delete(f.FilterStore, key)
+			}
+		}
+	}
+
+	return removed
+}
+
+// StartSweeper runs Sweep in a background goroutine at the given interval.  The returned
+// function stops the sweeper and is idempotent.
+func (f *FilterGate) StartSweeper(interval time.Duration) func() {
+	var (
+		ticker = time.NewTicker(interval)
+		done   = make(chan struct{})
+		once   sync.Once
+	)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				f.Sweep()
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
 func (f *FilterGate) DeleteFilter(key string) bool {
 	f.lock.Lock()
 	defer f.lock.Unlock()
@@ -136,6 +285,10 @@ func (f *FilterGate) AllowConnection(d device.Interface) (bool, device.MatchResu
 	f.lock.RLock()
 	defer f.lock.RUnlock()
 
+	if f.Mode == MatchModeAnd {
+		return f.allowConnectionAnd(d)
+	}
+
 	for filterKey, filterValues := range f.FilterStore {
 		// check for filter match
 		if found, result := f.FilterStore.metadataMatch(filterKey, filterValues, d.Metadata()); found {
@@ -146,6 +299,45 @@ func (f *FilterGate) AllowConnection(d device.Interface) (bool, device.MatchResu
 	return true, device.MatchResult{}
 }
 
+// allowConnectionAnd filters a device only if every filter key matches.  The returned MatchResult is
+// a composite of each key's match, in key order.
+func (f *FilterGate) allowConnectionAnd(d device.Interface) (bool, device.MatchResult) {
+	filterKeys := make([]string, 0, len(f.FilterStore))
+	for filterKey, filterValues := range f.FilterStore {
+		// a key whose values have all expired is ignored, just as it would be once swept
+		if fs, ok := filterValues.(*FilterSet); ok && fs.allExpired() {
+			continue
+		}
+
+		filterKeys = append(filterKeys, filterKey)
+	}
+
+	if len(filterKeys) == 0 {
+		return true, device.MatchResult{}
+	}
+
+	sort.Strings(filterKeys)
+	var keys, locations, patterns []string
+	for _, filterKey := range filterKeys {
+		found, result := f.FilterStore.metadataMatch(filterKey, f.FilterStore[filterKey], d.Metadata())
+		if !found {
+			return true, device.MatchResult{}
+		}
+
+		keys = append(keys, result.Key)
+		locations = append(locations, result.Location)
+		if len(result.Pattern) > 0 {
+			patterns = append(patterns, result.Pattern)
+		}
+	}
+
+	return false, device.MatchResult{
+		Location: strings.Join(locations, compositeSeparator),
+		Key:      strings.Join(keys, compositeSeparator),
+		Pattern:  strings.Join(patterns, compositeSeparator),
+	}
+}
+
 func (f *FilterGate) GetAllowedFilters() (Set, bool) {
 	if f.AllowedFilters == nil {
 		return f.AllowedFilters, false
@@ -158,7 +350,7 @@ func (s *FilterSet) Has(key interface{}) bool {
 	if s.Set != nil {
 		s.lock.RLock()
 		defer s.lock.RUnlock()
-		return s.Set[key]
+		return s.Set[key] && !s.expired(key, s.now())
 	}
 
 	return false
@@ -167,22 +359,79 @@ func (s *FilterSet) Has(key interface{}) bool {
 func (s *FilterSet) VisitAll(f func(interface{})) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
+	now := s.now()
 	for key := range s.Set {
-		f(key)
+		if !s.expired(key, now) {
+			f(key)
+		}
 	}
 }
 
 func (s *FilterSet) MarshalJSON() ([]byte, error) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
+	now := s.now()
 	temp := make([]interface{}, 0, len(s.Set))
 	for key := range s.Set {
-		temp = append(temp, key)
+		if !s.expired(key, now) {
+			temp = append(temp, key)
+		}
 	}
 
 	return json.Marshal(temp)
 }
 
+func (s *FilterSet) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+
+	return time.Now()
+}
+
+// expired tests if a value has an expiration at or before now.  The caller must hold the lock.
+func (s *FilterSet) expired(key interface{}, now time.Time) bool {
+	expires, ok := s.Expires[key]
+	return ok && !now.Before(expires)
+}
+
+// allExpired tests if this set has values and all of them have expired.  Sweep removes such sets.
+func (s *FilterSet) allExpired() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.Set) == 0 {
+		return false
+	}
+
+	now := s.now()
+	for key := range s.Set {
+		if !s.expired(key, now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// prune removes expired values, returning the number removed and whether the set is now empty
+func (s *FilterSet) prune() (int, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	removed := 0
+	now := s.now()
+	for key := range s.Expires {
+		if s.expired(key, now) {
+			delete(s.Set, key)
+			delete(s.Expires, key)
+			removed++
+		}
+	}
+
+	return removed, len(s.Set) == 0
+}
+
 func (f *FilterStore) metadataMatch(keyToCheck string, filterValues Set, m *device.Metadata) (bool, device.MatchResult) {
 	var val interface{}
 	result := device.MatchResult{
@@ -197,29 +446,39 @@ func (f *FilterStore) metadataMatch(keyToCheck string, filterValues Set, m *devi
 	}
 
 	if val != nil {
+		var (
+			pattern string
+			found   bool
+		)
+
 		switch t := val.(type) {
 		case []interface{}:
-			if filterMatch(filterValues, t...) {
-				return true, result
-			}
+			pattern, found = filterMatch(filterValues, t...)
 		case interface{}:
-			if filterMatch(filterValues, t) {
-				return true, result
-			}
+			pattern, found = filterMatch(filterValues, t)
+		}
+
+		if found {
+			result.Pattern = pattern
+			return true, result
 		}
 	}
 
 	return false, device.MatchResult{}
 }
 
-// function to check if any params are in a set
-func filterMatch(filterValues Set, paramsToCheck ...interface{}) bool {
+// function to check if any params are in a set.  If the set is a PatternSet, the matching pattern is returned.
+func filterMatch(filterValues Set, paramsToCheck ...interface{}) (string, bool) {
+	patterns, isPatternSet := filterValues.(PatternSet)
 	for _, param := range paramsToCheck {
-		if filterValues.Has(param) {
-			return true
+		if isPatternSet {
+			if pattern, matched := patterns.Match(param); matched {
+				return pattern, true
+			}
+		} else if filterValues.Has(param) {
+			return "", true
 		}
 	}
 
-	return false
-
+	return "", false
 }
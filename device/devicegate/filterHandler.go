@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/xmidt-org/sallust"
 	"github.com/xmidt-org/webpa-common/v2/xhttp"
@@ -21,6 +22,17 @@ const gateKey ContextKey = "gate"
 // FilterHandler is an http.Handler that can get, add, and delete filters from a devicegate Interface
 type FilterHandler struct {
 	Gate Interface
+
+	// Now is the clock used to compute expirations from a FilterRequest's TTL.  If nil, time.Now is used.
+	Now func() time.Time
+}
+
+func (fh *FilterHandler) now() time.Time {
+	if fh.Now != nil {
+		return fh.Now()
+	}
+
+	return time.Now()
 }
 
 // GateLogger is used to log extra details about the gate
@@ -53,7 +65,28 @@ func (fh *FilterHandler) UpdateFilters(response http.ResponseWriter, request *ht
 		return
 	}
 
-	if _, created := fh.Gate.SetFilter(message.Key, message.Values); created {
+	var created bool
+	switch message.Type {
+	case FilterTypeRegex, FilterTypeCIDR:
+		patterns, err := newPatternSet(message)
+		if err != nil {
+			logger.Error("invalid filter patterns", zap.Error(err))
+			xhttp.WriteError(response, http.StatusBadRequest, err)
+			return
+		}
+
+		_, created = fh.Gate.SetPatternFilter(message.Key, patterns)
+
+	default:
+		if len(message.TTL) > 0 {
+			ttl, _ := time.ParseDuration(message.TTL)
+			_, created = fh.Gate.SetFilterExpiring(message.Key, message.Values, fh.now().Add(ttl))
+		} else {
+			_, created = fh.Gate.SetFilter(message.Key, message.Values)
+		}
+	}
+
+	if created {
 		response.WriteHeader(http.StatusCreated)
 	} else {
 		response.WriteHeader(http.StatusOK)
@@ -127,6 +160,25 @@ func validateRequestBody(request *http.Request) (FilterRequest, error) {
 
 }
 
+// newPatternSet creates the PatternSet described by a FilterRequest whose values are patterns
+func newPatternSet(f FilterRequest) (PatternSet, error) {
+	patterns := make([]string, 0, len(f.Values))
+	for _, v := range f.Values {
+		p, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("filter pattern %v is not a string", v)
+		}
+
+		patterns = append(patterns, p)
+	}
+
+	if f.Type == FilterTypeCIDR {
+		return NewCIDRFilterSet(patterns...)
+	}
+
+	return NewRegexFilterSet(patterns...)
+}
+
 // validate content of request body
 func checkRequestDetails(f FilterRequest, gate Interface, checkFilterValues bool) (bool, error) {
 	if len(f.Key) == 0 {
@@ -138,6 +190,24 @@ func checkRequestDetails(f FilterRequest, gate Interface, checkFilterValues bool
 			return false, errors.New("missing filter values")
 		}
 
+		switch f.Type {
+		case "", FilterTypeExact, FilterTypeRegex, FilterTypeCIDR:
+		default:
+			return false, fmt.Errorf("invalid filter type %s", f.Type)
+		}
+
+		if len(f.TTL) > 0 {
+			if f.Type == FilterTypeRegex || f.Type == FilterTypeCIDR {
+				return false, fmt.Errorf("filter type %s does not support a ttl", f.Type)
+			}
+
+			if ttl, err := time.ParseDuration(f.TTL); err != nil {
+				return false, fmt.Errorf("invalid filter ttl %s: %w", f.TTL, err)
+			} else if ttl <= 0 {
+				return false, fmt.Errorf("filter ttl %s must be positive", f.TTL)
+			}
+		}
+
 		if allowedFilters, allowedFiltersFound := gate.GetAllowedFilters(); allowedFiltersFound {
 			if !allowedFilters.Has(f.Key) {
 				allowedFiltersJSON, _ := json.Marshal(allowedFilters)
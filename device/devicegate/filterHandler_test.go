@@ -3,13 +3,17 @@ package devicegate
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/sallust"
+	"github.com/xmidt-org/webpa-common/v2/device"
 )
 
 func TestServeHTTPGet(t *testing.T) {
@@ -178,6 +182,137 @@ func TestSuccessfulAdd(t *testing.T) {
 
 }
 
+func TestUpdatePatternFilters(t *testing.T) {
+	var (
+		logger = sallust.Default()
+		ctx    = sallust.With(context.Background(), logger)
+	)
+
+	tests := []struct {
+		description        string
+		reqBody            string
+		expectedStatusCode int
+		metadataValue      interface{}
+		expectedPattern    string
+	}{
+		{
+			description:        "Regex",
+			reqBody:            `{"key": "model", "type": "regex", "values": ["TG16.*", "DPC.*"]}`,
+			expectedStatusCode: http.StatusCreated,
+			metadataValue:      "DPC3941",
+			expectedPattern:    "DPC.*",
+		},
+		{
+			description:        "CIDR",
+			reqBody:            `{"key": "ip", "type": "cidr", "values": ["10.0.0.0/8"]}`,
+			expectedStatusCode: http.StatusCreated,
+			metadataValue:      "10.1.2.3",
+			expectedPattern:    "10.0.0.0/8",
+		},
+		{
+			description:        "Invalid Regex",
+			reqBody:            `{"key": "model", "type": "regex", "values": ["TG16("]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Invalid CIDR",
+			reqBody:            `{"key": "ip", "type": "cidr", "values": ["10.0.0.0"]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Pattern Not a String",
+			reqBody:            `{"key": "model", "type": "regex", "values": [123]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Unknown Type",
+			reqBody:            `{"key": "model", "type": "glob", "values": ["TG*"]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			var (
+				assert   = assert.New(t)
+				require  = require.New(t)
+				gate     = &FilterGate{FilterStore: make(FilterStore)}
+				f        = FilterHandler{Gate: gate}
+				response = httptest.NewRecorder()
+				request  = httptest.NewRequest("POST", "/", bytes.NewBufferString(tc.reqBody))
+			)
+
+			f.UpdateFilters(response, request.WithContext(ctx))
+			assert.Equal(tc.expectedStatusCode, response.Code)
+			if tc.expectedStatusCode != http.StatusCreated {
+				assert.Empty(gate.FilterStore)
+				return
+			}
+
+			var message FilterRequest
+			require.NoError(json.Unmarshal([]byte(tc.reqBody), &message))
+			filterValues, found := gate.GetFilter(message.Key)
+			require.True(found)
+			assert.Implements((*PatternSet)(nil), filterValues)
+
+			metadata := new(device.Metadata)
+			metadata.Store(message.Key, tc.metadataValue)
+			mockDevice := new(device.MockDevice)
+			// nolint: typecheck
+			mockDevice.On("Metadata").Return(metadata)
+
+			canPass, result := gate.AllowConnection(mockDevice)
+			assert.False(canPass)
+			assert.Equal(device.MatchResult{Location: metadataMapLocation, Key: message.Key, Pattern: tc.expectedPattern}, result)
+		})
+	}
+}
+
+func TestUpdateFiltersTTL(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		logger  = sallust.Default()
+		ctx     = sallust.With(context.Background(), logger)
+
+		now   = time.Now()
+		clock = func() time.Time { return now }
+		gate  = &FilterGate{FilterStore: make(FilterStore), Now: clock}
+		f     = FilterHandler{Gate: gate, Now: clock}
+
+		metadata = new(device.Metadata)
+	)
+
+	metadata.SetClaims(map[string]interface{}{"partner-id": "comcast"})
+	mockDevice := new(device.MockDevice)
+	// nolint: typecheck
+	mockDevice.On("Metadata").Return(metadata)
+
+	for _, reqBody := range []string{
+		`{"key": "partner-id", "values": ["comcast"], "ttl": "forever"}`,
+		`{"key": "partner-id", "values": ["comcast"], "ttl": "-1m"}`,
+		`{"key": "partner-id", "type": "regex", "values": ["com.*"], "ttl": "1m"}`,
+	} {
+		response := httptest.NewRecorder()
+		f.UpdateFilters(response, httptest.NewRequest("POST", "/", bytes.NewBufferString(reqBody)).WithContext(ctx))
+		assert.Equal(http.StatusBadRequest, response.Code, reqBody)
+	}
+
+	require.Empty(gate.FilterStore)
+
+	response := httptest.NewRecorder()
+	f.UpdateFilters(response, httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"key": "partner-id", "values": ["comcast"], "ttl": "1m"}`)).WithContext(ctx))
+	assert.Equal(http.StatusCreated, response.Code)
+
+	canPass, _ := gate.AllowConnection(mockDevice)
+	assert.False(canPass)
+
+	now = now.Add(time.Minute)
+	canPass, _ = gate.AllowConnection(mockDevice)
+	assert.True(canPass)
+	assert.Equal(1, gate.Sweep())
+}
+
 func TestDelete(t *testing.T) {
 	var (
 		logger   = sallust.Default()
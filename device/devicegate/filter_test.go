@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/webpa-common/v2/device"
 )
 
@@ -377,3 +379,226 @@ func TestMarshalJSON(t *testing.T) {
 
 	}
 }
+
+func TestFilterGateAllowConnectionMode(t *testing.T) {
+	tests := []struct {
+		description    string
+		mode           MatchMode
+		claims         map[string]interface{}
+		canPass        bool
+		expectedResult device.MatchResult
+	}{
+		{
+			description:    "Or Both Match",
+			mode:           MatchModeOr,
+			claims:         map[string]interface{}{"partner-id": "comcast", "model": "TG1682"},
+			expectedResult: device.MatchResult{Location: claimsLocation},
+		},
+		{
+			description:    "Or One Match",
+			claims:         map[string]interface{}{"partner-id": "comcast", "model": "other"},
+			expectedResult: device.MatchResult{Location: claimsLocation, Key: "partner-id"},
+		},
+		{
+			description: "Or No Match",
+			claims:      map[string]interface{}{"partner-id": "sky", "model": "other"},
+			canPass:     true,
+		},
+		{
+			description:    "And Both Match",
+			mode:           MatchModeAnd,
+			claims:         map[string]interface{}{"partner-id": "comcast", "model": "TG1682"},
+			expectedResult: device.MatchResult{Location: "claims,claims", Key: "model,partner-id"},
+		},
+		{
+			description: "And One Match",
+			mode:        MatchModeAnd,
+			claims:      map[string]interface{}{"partner-id": "comcast", "model": "other"},
+			canPass:     true,
+		},
+		{
+			description: "And No Match",
+			mode:        MatchModeAnd,
+			claims:      map[string]interface{}{"partner-id": "sky", "model": "other"},
+			canPass:     true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			metadata := new(device.Metadata)
+			metadata.SetClaims(tc.claims)
+
+			mockDevice := new(device.MockDevice)
+			// nolint: typecheck
+			mockDevice.On("Metadata").Return(metadata)
+
+			fg := FilterGate{
+				FilterStore: FilterStore{
+					"partner-id": &FilterSet{Set: map[interface{}]bool{"comcast": true}},
+					"model":      &FilterSet{Set: map[interface{}]bool{"TG1682": true}},
+				},
+				Mode: tc.mode,
+			}
+
+			canPass, result := fg.AllowConnection(mockDevice)
+			assert.Equal(tc.canPass, canPass)
+			if tc.canPass {
+				assert.Equal(device.MatchResult{}, result)
+			} else if len(tc.expectedResult.Key) > 0 {
+				assert.Equal(tc.expectedResult, result)
+			} else {
+				// in OR mode, either key may be reported when both match
+				assert.Equal(tc.expectedResult.Location, result.Location)
+				assert.Contains([]string{"partner-id", "model"}, result.Key)
+			}
+		})
+	}
+}
+
+func TestFilterGateMarshalMode(t *testing.T) {
+	fg := FilterGate{
+		FilterStore: FilterStore{},
+		Mode:        MatchModeAnd,
+	}
+
+	data, err := json.Marshal(&fg)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"filters": {}, "allowedFilters": null, "mode": "and"}`, string(data))
+}
+
+func TestFilterGateExpiring(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		now = time.Now()
+		fg  = FilterGate{
+			FilterStore: make(FilterStore),
+			Now:         func() time.Time { return now },
+		}
+
+		metadata = new(device.Metadata)
+	)
+
+	metadata.SetClaims(map[string]interface{}{"partner-id": "comcast"})
+	mockDevice := new(device.MockDevice)
+	// nolint: typecheck
+	mockDevice.On("Metadata").Return(metadata)
+
+	_, created := fg.SetFilterExpiring("partner-id", []interface{}{"comcast", "sky"}, now.Add(time.Minute))
+	assert.True(created)
+	fg.SetFilter("model", []interface{}{"TG1682"})
+
+	canPass, result := fg.AllowConnection(mockDevice)
+	assert.False(canPass)
+	assert.Equal(device.MatchResult{Location: claimsLocation, Key: "partner-id"}, result)
+	assert.Zero(fg.Sweep())
+
+	now = now.Add(time.Minute)
+	canPass, result = fg.AllowConnection(mockDevice)
+	assert.True(canPass)
+	assert.Equal(device.MatchResult{}, result)
+
+	values, found := fg.GetFilter("partner-id")
+	require.True(found)
+	assert.False(values.Has("sky"))
+	data, err := json.Marshal(values)
+	require.NoError(err)
+	assert.JSONEq(`[]`, string(data))
+
+	assert.Equal(2, fg.Sweep())
+	_, found = fg.GetFilter("partner-id")
+	assert.False(found)
+	_, found = fg.GetFilter("model")
+	assert.True(found, "filters without expirations should not be swept")
+}
+
+func TestFilterGateExpiringAnd(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		now = time.Now()
+		fg  = FilterGate{
+			FilterStore: make(FilterStore),
+			Mode:        MatchModeAnd,
+			Now:         func() time.Time { return now },
+		}
+
+		metadata = new(device.Metadata)
+	)
+
+	metadata.SetClaims(map[string]interface{}{"partner-id": "comcast", "model": "TG1682"})
+	mockDevice := new(device.MockDevice)
+	// nolint: typecheck
+	mockDevice.On("Metadata").Return(metadata)
+
+	fg.SetFilterExpiring("fw-name", []interface{}{"1.0"}, now.Add(time.Minute))
+	fg.SetFilter("partner-id", []interface{}{"comcast"})
+	fg.SetFilter("model", []interface{}{"TG1682"})
+
+	// the device doesn't match fw-name while it is in effect
+	canPass, result := fg.AllowConnection(mockDevice)
+	assert.True(canPass)
+	assert.Equal(device.MatchResult{}, result)
+
+	// once fw-name expires, the decision must not depend on whether it has been swept
+	now = now.Add(time.Minute)
+	expected := device.MatchResult{Location: "claims,claims", Key: "model,partner-id"}
+
+	canPass, result = fg.AllowConnection(mockDevice)
+	assert.False(canPass, "an expired key should be ignored before it is swept")
+	assert.Equal(expected, result)
+
+	assert.Equal(1, fg.Sweep())
+	canPass, result = fg.AllowConnection(mockDevice)
+	assert.False(canPass)
+	assert.Equal(expected, result)
+}
+
+func TestFilterSetExpires(t *testing.T) {
+	var (
+		assert = assert.New(t)
+
+		now = time.Now()
+		fs  = &FilterSet{
+			Set:     map[interface{}]bool{"permanent": true, "temporary": true},
+			Expires: map[interface{}]time.Time{"temporary": now.Add(time.Second)},
+			Now:     func() time.Time { return now },
+		}
+	)
+
+	assert.True(fs.Has("permanent"))
+	assert.True(fs.Has("temporary"))
+
+	now = now.Add(time.Second)
+	assert.True(fs.Has("permanent"))
+	assert.False(fs.Has("temporary"))
+
+	var visited []interface{}
+	fs.VisitAll(func(v interface{}) { visited = append(visited, v) })
+	assert.Equal([]interface{}{"permanent"}, visited)
+
+	removed, empty := fs.prune()
+	assert.Equal(1, removed)
+	assert.False(empty)
+	assert.Equal(map[interface{}]bool{"permanent": true}, fs.Set)
+	assert.Empty(fs.Expires)
+}
+
+func TestFilterGateStartSweeper(t *testing.T) {
+	fg := FilterGate{FilterStore: make(FilterStore)}
+	fg.SetFilterExpiring("partner-id", []interface{}{"comcast"}, time.Now())
+
+	stop := fg.StartSweeper(10 * time.Millisecond)
+	defer stop()
+
+	assert.Eventually(t, func() bool {
+		_, found := fg.GetFilter("partner-id")
+		return !found
+	}, time.Second, 10*time.Millisecond)
+
+	stop()
+}
@@ -1,6 +1,8 @@
 package devicegate
 
 import (
+	"time"
+
 	"github.com/stretchr/testify/mock"
 	"github.com/xmidt-org/webpa-common/v2/device"
 )
@@ -29,6 +31,20 @@ func (m *mockDeviceGate) SetFilter(key string, values []interface{}) (Set, bool)
 	return set, args.Bool(1)
 }
 
+func (m *mockDeviceGate) SetPatternFilter(key string, patterns PatternSet) (Set, bool) {
+	// nolint: typecheck
+	args := m.Called(key, patterns)
+	set, _ := args.Get(0).(Set)
+	return set, args.Bool(1)
+}
+
+func (m *mockDeviceGate) SetFilterExpiring(key string, values []interface{}, expires time.Time) (Set, bool) {
+	// nolint: typecheck
+	args := m.Called(key, values, expires)
+	set, _ := args.Get(0).(Set)
+	return set, args.Bool(1)
+}
+
 func (m *mockDeviceGate) DeleteFilter(key string) bool {
 	// nolint: typecheck
 	args := m.Called(key)
@@ -0,0 +1,67 @@
+package devicegate
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// RegexFilterSet is a PatternSet whose values are regular expressions.  A device value matches
+// if it is a string that is entirely matched by any of the expressions.
+type RegexFilterSet struct {
+	patterns []string
+	regexps  []*regexp.Regexp
+}
+
+// NewRegexFilterSet compiles each pattern into a RegexFilterSet.  Each pattern must match the whole
+// device value, e.g. `1\.2\..*` matches "1.2.3" but not "11.2.3".  An error is returned if any pattern
+// fails to compile.
+func NewRegexFilterSet(patterns ...string) (*RegexFilterSet, error) {
+	rs := &RegexFilterSet{
+		patterns: make([]string, 0, len(patterns)),
+		regexps:  make([]*regexp.Regexp, 0, len(patterns)),
+	}
+
+	for _, p := range patterns {
+		r, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter pattern %q: %w", p, err)
+		}
+
+		rs.patterns = append(rs.patterns, p)
+		rs.regexps = append(rs.regexps, r)
+	}
+
+	return rs, nil
+}
+
+// Match returns the first pattern that matches the value, which must be a string.
+func (rs *RegexFilterSet) Match(value interface{}) (string, bool) {
+	if s, ok := value.(string); ok {
+		for i, r := range rs.regexps {
+			if r.MatchString(s) {
+				return rs.patterns[i], true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Has returns true if any pattern matches the value
+func (rs *RegexFilterSet) Has(value interface{}) bool {
+	_, matched := rs.Match(value)
+	return matched
+}
+
+// VisitAll applies the visitor function to each pattern
+func (rs *RegexFilterSet) VisitAll(f func(interface{})) {
+	for _, p := range rs.patterns {
+		f(p)
+	}
+}
+
+// MarshalJSON writes the patterns as a JSON array
+func (rs *RegexFilterSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rs.patterns)
+}
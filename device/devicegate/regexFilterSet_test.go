@@ -0,0 +1,102 @@
+package devicegate
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/webpa-common/v2/device"
+)
+
+func TestNewRegexFilterSet(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		var (
+			assert  = assert.New(t)
+			require = require.New(t)
+		)
+
+		rs, err := NewRegexFilterSet(`1\.2\..*`, `2\.0`)
+		require.NoError(err)
+		require.NotNil(rs)
+
+		var visited []interface{}
+		rs.VisitAll(func(v interface{}) { visited = append(visited, v) })
+		assert.Equal([]interface{}{`1\.2\..*`, `2\.0`}, visited)
+
+		data, err := json.Marshal(rs)
+		require.NoError(err)
+		assert.JSONEq(`["1\\.2\\..*", "2\\.0"]`, string(data))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		rs, err := NewRegexFilterSet(`1\.2\..*`, `(unclosed`)
+		assert.Nil(t, rs)
+		assert.Error(t, err)
+	})
+}
+
+func TestRegexFilterSetMatch(t *testing.T) {
+	rs, err := NewRegexFilterSet(`1\.2\..*`, `2\.0`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		value           interface{}
+		expectedPattern string
+		expectedMatch   bool
+	}{
+		{value: "1.2.3", expectedPattern: `1\.2\..*`, expectedMatch: true},
+		{value: "1.2.", expectedPattern: `1\.2\..*`, expectedMatch: true},
+		{value: "2.0", expectedPattern: `2\.0`, expectedMatch: true},
+		{value: "11.2.3"},
+		{value: "1.3.0"},
+		{value: "2.0.1"},
+		{value: 12},
+	}
+
+	for _, tc := range tests {
+		pattern, matched := rs.Match(tc.value)
+		assert.Equal(t, tc.expectedMatch, matched, tc.value)
+		assert.Equal(t, tc.expectedPattern, pattern, tc.value)
+		assert.Equal(t, tc.expectedMatch, rs.Has(tc.value), tc.value)
+	}
+}
+
+func TestFilterGateAllowConnectionRegex(t *testing.T) {
+	rs, err := NewRegexFilterSet(`1\.2\..*`)
+	require.NoError(t, err)
+
+	fg := FilterGate{
+		FilterStore: FilterStore{"fw-name": rs},
+	}
+
+	tests := []struct {
+		firmware       string
+		canPass        bool
+		expectedResult device.MatchResult
+	}{
+		{
+			firmware:       "1.2.3",
+			expectedResult: device.MatchResult{Location: claimsLocation, Key: "fw-name", Pattern: `1\.2\..*`},
+		},
+		{
+			firmware: "1.3.0",
+			canPass:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.firmware, func(t *testing.T) {
+			metadata := new(device.Metadata)
+			metadata.SetClaims(map[string]interface{}{"fw-name": tc.firmware})
+
+			mockDevice := new(device.MockDevice)
+			// nolint: typecheck
+			mockDevice.On("Metadata").Return(metadata)
+
+			canPass, result := fg.AllowConnection(mockDevice)
+			assert.Equal(t, tc.canPass, canPass)
+			assert.Equal(t, tc.expectedResult, result)
+		})
+	}
+}
@@ -20,6 +20,8 @@ import (
 var (
 	ErrActive    error = errors.New("a drain operation is already running")
 	ErrNotActive error = errors.New("no drain operation is running")
+	ErrPaused    error = errors.New("the drain operation is already paused")
+	ErrNotPaused error = errors.New("the drain operation is not paused")
 )
 
 const (
@@ -28,6 +30,7 @@ const (
 
 	MetricNotDraining float64 = 0.0
 	MetricDraining    float64 = 1.0
+	MetricPaused      float64 = 2.0
 
 	Drained = "drained"
 
@@ -190,6 +193,15 @@ type Interface interface {
 	// Cancel asynchronously halts any running drain job.  The returned channel can be used to wait for the job to actually exit.
 	// If no job is running, an error is returned along with a nil channel.
 	Cancel() (<-chan struct{}, error)
+
+	// Pause temporarily halts the running drain job without losing its progress.  A batch of devices that is
+	// already being disconnected is allowed to finish.  If no job is running, ErrNotActive is returned.  If the
+	// job is already paused, ErrPaused is returned.
+	Pause() error
+
+	// Resume continues a paused drain job.  If no job is running, ErrNotActive is returned.  If the job
+	// is not paused, ErrNotPaused is returned.
+	Resume() error
 }
 
 func defaultNewTicker(d time.Duration) (<-chan time.Time, func()) {
@@ -232,6 +244,48 @@ type metrics struct {
 	counter xmetrics.Adder
 }
 
+// pauser tracks whether a drain job is paused
+type pauser struct {
+	lock    sync.Mutex
+	resumed chan struct{}
+}
+
+// pause marks the job as paused, returning false if it was already paused
+func (p *pauser) pause() bool {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+
+	if p.resumed != nil {
+		return false
+	}
+
+	p.resumed = make(chan struct{})
+	return true
+}
+
+// resume marks the job as no longer paused, returning false if it was not paused
+func (p *pauser) resume() bool {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+
+	if p.resumed == nil {
+		return false
+	}
+
+	close(p.resumed)
+	p.resumed = nil
+	return true
+}
+
+// paused returns a channel that is closed when the job is resumed.  If the job
+// is not paused, this method returns nil.
+func (p *pauser) paused() <-chan struct{} {
+	defer p.lock.Unlock()
+	p.lock.Lock()
+
+	return p.resumed
+}
+
 // jobContext stores all the runtime information for a drain job
 type jobContext struct {
 	id        uint32
@@ -241,6 +295,7 @@ type jobContext struct {
 	batchSize int
 	ticker    <-chan time.Time
 	stop      func()
+	pauser    *pauser
 	cancel    chan struct{}
 	done      chan struct{}
 }
@@ -278,6 +333,23 @@ func (df *drainFilter) AllowConnection(d device.Interface) (bool, device.MatchRe
 	return df.filter.AllowConnection(d)
 }
 
+// DrainByPartner produces a DrainFilter that selects devices whose partner-id claim
+// matches the given partner ID.  The returned filter may be used alongside a Job's Count and Rate.
+func DrainByPartner(partnerID string) DrainFilter {
+	filterRequest := devicegate.FilterRequest{
+		Key:    device.PartnerIDClaimKey,
+		Values: []interface{}{partnerID},
+	}
+
+	fg := devicegate.FilterGate{FilterStore: make(devicegate.FilterStore)}
+	fg.SetFilter(filterRequest.Key, filterRequest.Values)
+
+	return &drainFilter{
+		filter:        &fg,
+		filterRequest: filterRequest,
+	}
+}
+
 // nextBatch grabs a batch of devices, bounded by the size of the supplied batch channel, and attempts
 // to disconnect each of them.  This method is sensitive to the jc.cancel channel.  If canceled, or if
 // no more devices are available, this method returns false.
@@ -365,9 +437,37 @@ func (dr *drainer) jobFinished(jc jobContext) {
 	jc.logger.Info("drain complete", zap.Int("visited", p.Visited), zap.Int("drained", p.Drained))
 }
 
+// awaitResume blocks while the job is paused.  Any ticker is stopped while paused and replaced
+// when the job resumes.  This method returns false if the job was canceled while paused.
+func (dr *drainer) awaitResume(jc *jobContext) bool {
+	resumed := jc.pauser.paused()
+	if resumed == nil {
+		return true
+	}
+
+	jc.logger.Info("drain paused")
+	if jc.stop != nil {
+		jc.stop()
+	}
+
+	select {
+	case <-resumed:
+		jc.logger.Info("drain resumed")
+		if jc.ticker != nil {
+			jc.ticker, jc.stop = dr.newTicker(jc.j.Tick)
+		}
+
+		return true
+	case <-jc.cancel:
+		jc.logger.Error("job canceled", zap.Error(nil))
+		return false
+	}
+}
+
 // drain is run as a goroutine to drain devices at a particular rate
 func (dr *drainer) drain(jc jobContext) {
-	defer dr.jobFinished(jc)
+	// the ticker can be replaced when paused, so jobFinished must see the final jobContext
+	defer func() { dr.jobFinished(jc) }()
 	jc.logger.Info("drain starting", zap.Int("count", jc.j.Count), zap.Int("rate", jc.j.Rate), zap.Duration("tick", jc.j.Tick))
 
 	var (
@@ -385,6 +485,11 @@ func (dr *drainer) drain(jc jobContext) {
 
 		select {
 		case <-jc.ticker:
+			if !dr.awaitResume(&jc) {
+				more = false
+				break
+			}
+
 			more, visited, skipped = dr.nextBatch(jc, batch)
 			remaining -= visited
 
@@ -417,6 +522,10 @@ func (dr *drainer) disconnect(jc jobContext) {
 			batch = make(chan device.ID, remaining)
 		}
 
+		if !dr.awaitResume(&jc) {
+			break
+		}
+
 		more, visited, _ = dr.nextBatch(jc, batch)
 		remaining -= visited
 	}
@@ -441,6 +550,7 @@ func (dr *drainer) Start(j Job) (<-chan struct{}, Job, error) {
 			counter: dr.m.counter,
 		},
 		j:      j,
+		pauser: new(pauser),
 		cancel: make(chan struct{}),
 		done:   make(chan struct{}),
 	}
@@ -458,14 +568,37 @@ func (dr *drainer) Start(j Job) (<-chan struct{}, Job, error) {
 	return jc.done, jc.j, nil
 }
 
+// estimateFinish computes when a rate-limited job will finish, assuming one batch is
+// handled per remaining tick.  Jobs without a rate produce a nil estimate.
+func (dr *drainer) estimateFinish(j Job, visited int) *time.Time {
+	if j.Rate <= 0 || j.Tick <= 0 {
+		return nil
+	}
+
+	remaining := j.Count - visited
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	ticks := (remaining + j.Rate - 1) / j.Rate
+	estimate := dr.now().Add(time.Duration(ticks) * j.Tick).UTC()
+	return &estimate
+}
+
 func (dr *drainer) Status() (bool, Job, Progress) {
 	defer dr.controlLock.RUnlock()
 	dr.controlLock.RLock()
 
 	if jc, ok := dr.current.Load().(jobContext); ok {
-		return atomic.LoadUint32(&dr.active) == StateActive,
-			jc.j,
-			jc.t.Progress()
+		active := atomic.LoadUint32(&dr.active) == StateActive
+		progress := jc.t.Progress()
+		progress.Paused = active && jc.pauser.paused() != nil
+		if active && !progress.Paused {
+			// a paused job makes no progress, so there is no meaningful finish time to estimate
+			progress.EstimatedFinish = dr.estimateFinish(jc.j, progress.Visited)
+		}
+
+		return active, jc.j, progress
 	}
 
 	// if the job has never run, this result will be returned
@@ -485,3 +618,37 @@ func (dr *drainer) Cancel() (<-chan struct{}, error) {
 	close(jc.cancel)
 	return jc.done, nil
 }
+
+func (dr *drainer) Pause() error {
+	defer dr.controlLock.Unlock()
+	dr.controlLock.Lock()
+
+	if atomic.LoadUint32(&dr.active) != StateActive {
+		return ErrNotActive
+	}
+
+	jc := dr.current.Load().(jobContext)
+	if !jc.pauser.pause() {
+		return ErrPaused
+	}
+
+	dr.m.state.Set(MetricPaused)
+	return nil
+}
+
+func (dr *drainer) Resume() error {
+	defer dr.controlLock.Unlock()
+	dr.controlLock.Lock()
+
+	if atomic.LoadUint32(&dr.active) != StateActive {
+		return ErrNotActive
+	}
+
+	jc := dr.current.Load().(jobContext)
+	if !jc.pauser.resume() {
+		return ErrNotPaused
+	}
+
+	dr.m.state.Set(MetricDraining)
+	return nil
+}
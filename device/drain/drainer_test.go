@@ -3,6 +3,7 @@ package drain
 import (
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -318,7 +319,8 @@ func testDrainerDrainAll(t *testing.T, deviceCount int) {
 	active, job, progress = d.Status()
 	assert.True(active)
 	assert.Equal(Job{Count: deviceCount, Rate: 100, Tick: time.Second}, job)
-	assert.Equal(Progress{Visited: 0, Drained: 0, Started: expectedStarted.UTC(), Finished: nil}, progress)
+	expectedEstimate := expectedFinished.Add(time.Duration((deviceCount+99)/100) * time.Second).UTC()
+	assert.Equal(Progress{Visited: 0, Drained: 0, Started: expectedStarted.UTC(), Finished: nil, EstimatedFinish: &expectedEstimate}, progress)
 
 	go func() {
 		ticks := deviceCount / 100
@@ -605,6 +607,234 @@ func testDrainerDrainCancel(t *testing.T) {
 	assert.True(stopCalled)
 }
 
+func testDrainerPauseResume(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		provider = xmetricstest.NewProvider(nil)
+		logger   = sallust.Default()
+
+		manager = generateManager(assert, 300)
+
+		stopCount int32
+		stop      = func() {
+			atomic.AddInt32(&stopCount, 1)
+		}
+
+		ticker = make(chan time.Time, 1)
+
+		d = New(
+			WithLogger(logger),
+			WithRegistry(manager),
+			WithConnector(manager),
+			WithStateGauge(provider.NewGauge("state")),
+			WithDrainCounter(provider.NewCounter("counter")),
+		)
+	)
+
+	require.NotNil(d)
+	d.(*drainer).newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		assert.Equal(time.Second, d)
+		return ticker, stop
+	}
+
+	defer d.Cancel() // cleanup in case of horribleness
+
+	assert.Equal(ErrNotActive, d.Pause())
+	assert.Equal(ErrNotActive, d.Resume())
+
+	close(manager.pauseDisconnect)
+	close(manager.pauseVisit)
+	done, _, err := d.Start(Job{Rate: 100, Tick: time.Second})
+	require.NoError(err)
+	require.NotNil(done)
+
+	ticker <- time.Time{}
+	require.Eventually(
+		func() bool {
+			_, _, progress := d.Status()
+			return progress.Drained == 100
+		},
+		5*time.Second,
+		10*time.Millisecond,
+	)
+
+	assert.Equal(ErrNotPaused, d.Resume())
+	require.NoError(d.Pause())
+	assert.Equal(ErrPaused, d.Pause())
+	provider.Assert(t, "state")(xmetricstest.Value(MetricPaused))
+
+	active, _, progress := d.Status()
+	assert.True(active)
+	assert.True(progress.Paused)
+
+	// this tick must not result in any further disconnections
+	ticker <- time.Time{}
+	time.Sleep(100 * time.Millisecond)
+
+	active, _, progress = d.Status()
+	assert.True(active)
+	assert.Equal(100, progress.Visited)
+	assert.Equal(100, progress.Drained)
+	assert.Len(manager.devices, 200)
+
+	require.NoError(d.Resume())
+	provider.Assert(t, "state")(xmetricstest.Value(MetricDraining))
+
+	ticker <- time.Time{}
+	select {
+	case <-done:
+		// passed
+	case <-time.After(5 * time.Second):
+		assert.Fail("Drain failed to complete")
+		return
+	}
+
+	provider.Assert(t, "state")(xmetricstest.Value(MetricNotDraining))
+	provider.Assert(t, "counter")(xmetricstest.Value(300.0))
+
+	active, _, progress = d.Status()
+	assert.False(active)
+	assert.False(progress.Paused)
+	assert.Equal(300, progress.Drained)
+	assert.Empty(manager.devices)
+
+	// once when paused, once when the job finished
+	assert.Equal(int32(2), atomic.LoadInt32(&stopCount))
+}
+
+func testDrainerEstimatedFinish(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		provider = xmetricstest.NewProvider(nil)
+		logger   = sallust.Default()
+
+		manager = generateManager(assert, 250)
+
+		started = time.Now()
+		elapsed int64
+
+		ticker = make(chan time.Time, 1)
+
+		d = New(
+			WithLogger(logger),
+			WithRegistry(manager),
+			WithConnector(manager),
+			WithStateGauge(provider.NewGauge("state")),
+			WithDrainCounter(provider.NewCounter("counter")),
+		)
+	)
+
+	require.NotNil(d)
+	d.(*drainer).now = func() time.Time {
+		return started.Add(time.Duration(atomic.LoadInt64(&elapsed)))
+	}
+
+	d.(*drainer).newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		assert.Equal(time.Second, d)
+		return ticker, func() {}
+	}
+
+	defer d.Cancel() // cleanup in case of horribleness
+
+	close(manager.pauseDisconnect)
+	close(manager.pauseVisit)
+	done, _, err := d.Start(Job{Rate: 100, Tick: time.Second})
+	require.NoError(err)
+	require.NotNil(done)
+
+	expectedFinish := started.Add(3 * time.Second).UTC()
+	for tick, expectedVisited := range []int{100, 200} {
+		active, _, progress := d.Status()
+		assert.True(active)
+		require.NotNil(progress.EstimatedFinish)
+		assert.Equal(expectedFinish, *progress.EstimatedFinish)
+		assert.Equal(time.Duration(3-tick)*time.Second, progress.EstimatedFinish.Sub(d.(*drainer).now()))
+
+		atomic.AddInt64(&elapsed, int64(time.Second))
+		ticker <- time.Time{}
+		require.Eventually(
+			func() bool {
+				_, _, progress := d.Status()
+				return progress.Visited == expectedVisited
+			},
+			5*time.Second,
+			10*time.Millisecond,
+		)
+	}
+
+	active, _, progress := d.Status()
+	assert.True(active)
+	require.NotNil(progress.EstimatedFinish)
+	assert.Equal(expectedFinish, *progress.EstimatedFinish)
+	assert.Equal(time.Second, progress.EstimatedFinish.Sub(d.(*drainer).now()))
+
+	// no estimate is made while paused, no matter how much time passes
+	require.NoError(d.Pause())
+	for i := 0; i < 2; i++ {
+		active, _, progress = d.Status()
+		assert.True(active)
+		assert.True(progress.Paused)
+		assert.Nil(progress.EstimatedFinish)
+		atomic.AddInt64(&elapsed, int64(10*time.Second))
+	}
+
+	// once resumed, the estimate picks up from the current time
+	require.NoError(d.Resume())
+	active, _, progress = d.Status()
+	assert.True(active)
+	assert.False(progress.Paused)
+	require.NotNil(progress.EstimatedFinish)
+	assert.Equal(time.Second, progress.EstimatedFinish.Sub(d.(*drainer).now()))
+
+	ticker <- time.Time{}
+	select {
+	case <-done:
+		// passed
+	case <-time.After(5 * time.Second):
+		assert.Fail("Drain failed to complete")
+		return
+	}
+
+	active, _, progress = d.Status()
+	assert.False(active)
+	assert.Nil(progress.EstimatedFinish)
+}
+
+func testDrainerDisconnectNoEstimate(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		manager = generateManager(assert, 10)
+
+		d = New(
+			WithRegistry(manager),
+			WithConnector(manager),
+		)
+	)
+
+	require.NotNil(d)
+	defer d.Cancel() // cleanup in case of horribleness
+
+	done, _, err := d.Start(Job{})
+	require.NoError(err)
+	require.NotNil(done)
+
+	active, _, progress := d.Status()
+	assert.True(active)
+	assert.Nil(progress.EstimatedFinish)
+
+	close(manager.pauseDisconnect)
+	close(manager.pauseVisit)
+	select {
+	case <-done:
+		// passed
+	case <-time.After(5 * time.Second):
+		assert.Fail("Disconnect failed to complete")
+	}
+}
+
 func TestDrainer(t *testing.T) {
 	deviceCounts := []int{0, 1, 2, disconnectBatchSize - 1, disconnectBatchSize, disconnectBatchSize + 1, 1709}
 
@@ -627,6 +857,9 @@ func TestDrainer(t *testing.T) {
 	t.Run("VisitCancel", testDrainerVisitCancel)
 	t.Run("DisconnectCancel", testDrainerDisconnectCancel)
 	t.Run("DrainCancel", testDrainerDrainCancel)
+	t.Run("PauseResume", testDrainerPauseResume)
+	t.Run("EstimatedFinish", testDrainerEstimatedFinish)
+	t.Run("DisconnectNoEstimate", testDrainerDisconnectNoEstimate)
 }
 
 func testDrainFilter(t *testing.T, deviceTypeOne deviceInfo, deviceTypeTwo deviceInfo, df DrainFilter, expectedSkipped int, count int) {
@@ -724,7 +957,8 @@ func testDrainFilter(t *testing.T, deviceTypeOne deviceInfo, deviceTypeTwo devic
 	assert.True(active)
 	assert.Equal(Job{Count: realCount, Rate: 100, Tick: time.Second, DrainFilter: df}, job)
 
-	assert.Equal(Progress{Visited: 0, Drained: 0, Started: expectedStarted.UTC(), Finished: nil}, progress)
+	expectedEstimate := expectedFinished.Add(time.Duration((realCount+99)/100) * time.Second).UTC()
+	assert.Equal(Progress{Visited: 0, Drained: 0, Started: expectedStarted.UTC(), Finished: nil, EstimatedFinish: &expectedEstimate}, progress)
 
 	go func() {
 		ticks := realCount / 100
@@ -988,6 +1222,56 @@ func TestDrainerWithFilter(t *testing.T) {
 	}
 }
 
+func TestDrainByPartner(t *testing.T) {
+	var (
+		partnerID = "comcast"
+		df        = DrainByPartner(partnerID)
+
+		metadata1 = map[string]interface{}{device.PartnerIDClaimKey: "sky"}
+		metadata2 = map[string]interface{}{device.PartnerIDClaimKey: partnerID}
+
+		counts = [][]int{
+			[]int{0, 0, 100},
+			[]int{1, 0, 1},
+			[]int{0, 1, 100},
+			[]int{1, 1, 19},
+			[]int{0, disconnectBatchSize + 1, 120},
+			[]int{disconnectBatchSize + 1, 0, 400},
+			[]int{89, 1709, 1091},
+			[]int{1704, 43, 1000},
+		}
+	)
+
+	require.NotNil(t, df)
+	assert.Equal(t,
+		devicegate.FilterRequest{Key: device.PartnerIDClaimKey, Values: []interface{}{partnerID}},
+		df.GetFilterRequest(),
+	)
+
+	for _, deviceCount := range counts {
+		expectedSkip := deviceCount[0]
+		devices := []deviceInfo{
+			deviceInfo{count: deviceCount[0], claims: metadata1},
+			deviceInfo{count: deviceCount[1], claims: metadata2},
+		}
+
+		t.Run(fmt.Sprintf("deviceCount=%d", deviceCount[0]+deviceCount[1]), func(t *testing.T) {
+			t.Run("DrainAll", func(t *testing.T) {
+				testDrainFilter(t, devices[0], devices[1], df, expectedSkip, -1)
+			})
+			t.Run("DrainWithCount", func(t *testing.T) {
+				testDrainFilter(t, devices[0], devices[1], df, expectedSkip, deviceCount[2])
+			})
+			t.Run("DisconnectAll", func(t *testing.T) {
+				testDisconnectFilter(t, devices[0], devices[1], df, expectedSkip, -1)
+			})
+			t.Run("DisconnectWithCount", func(t *testing.T) {
+				testDisconnectFilter(t, devices[0], devices[1], df, expectedSkip, deviceCount[2])
+			})
+		})
+	}
+}
+
 func TestDrainFilterNilFilter(t *testing.T) {
 	assert := assert.New(t)
 	mockDevice := new(device.MockDevice)
@@ -1,6 +1,7 @@
 package drain
 
 import (
+	"context"
 	"net/http"
 	"sync"
 
@@ -31,6 +32,16 @@ func (m *mockDrainer) Cancel() (<-chan struct{}, error) {
 	return arguments.Get(0).(<-chan struct{}), arguments.Error(1)
 }
 
+func (m *mockDrainer) Pause() error {
+	// nolint: typecheck
+	return m.Called().Error(0)
+}
+
+func (m *mockDrainer) Resume() error {
+	// nolint: typecheck
+	return m.Called().Error(0)
+}
+
 type stubManager struct {
 	lock    sync.RWMutex
 	assert  *assert.Assertions
@@ -93,6 +104,16 @@ func (sm *stubManager) Get(device.ID) (device.Interface, bool) {
 	return nil, false
 }
 
+func (sm *stubManager) VisitAllCtx(context.Context, func(device.Interface) bool) int {
+	sm.assert.Fail("VisitAllCtx is not supported")
+	return 0
+}
+
+func (sm *stubManager) GetMany([]device.ID) map[device.ID]device.Interface {
+	sm.assert.Fail("GetMany is not supported")
+	return nil
+}
+
 func (sm *stubManager) VisitAll(p func(device.Interface) bool) (count int) {
 	select {
 	case sm.visit <- struct{}{}:
@@ -189,3 +210,13 @@ func generateManagerWithDifferentDevices(assert *assert.Assertions, metadataOneC
 func (sm *stubManager) MaxDevices() int {
 	return 1
 }
+
+func (sm *stubManager) AddListener(device.Listener) func() {
+	sm.assert.Fail("AddListener is not supported")
+	return func() {}
+}
+
+func (sm *stubManager) UpdateMetadata(device.ID, func(*device.Metadata)) bool {
+	sm.assert.Fail("UpdateMetadata is not supported")
+	return false
+}
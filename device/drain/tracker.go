@@ -25,6 +25,14 @@ type Progress struct {
 	// Finished is the UTC system time at which the drain job finished or was canceled.
 	// If the job is running, this field will be nil.
 	Finished *time.Time `json:"finished,omitempty"`
+
+	// EstimatedFinish is the UTC system time at which a running drain job is expected to finish,
+	// based on the job's rate and the number of devices remaining.  This field is nil for jobs
+	// that have no rate, that are paused, or that are no longer running.
+	EstimatedFinish *time.Time `json:"estimatedFinish,omitempty"`
+
+	// Paused indicates whether the running drain job is currently paused.
+	Paused bool `json:"paused,omitempty"`
 }
 
 type tracker struct {
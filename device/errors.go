@@ -2,6 +2,7 @@ package device
 
 import (
 	"errors"
+	"time"
 )
 
 var (
@@ -25,4 +26,24 @@ var (
 	ErrorTransactionsClosed           = errors.New("Transactions are closed for that device")
 	ErrorTransactionsAlreadyClosed    = errors.New("That Transactions is already closed")
 	ErrorDeviceFilteredOut            = errors.New("Device blocked from connecting due to filters")
+	ErrorDeviceQueueFull              = errors.New("That device's message queue is full")
+	ErrorConnectRateLimited           = errors.New("Too many devices are connecting")
+	ErrorUnsupportedSubprotocol       = errors.New("None of the requested websocket subprotocols are supported")
+	ErrorDestinationMismatch          = errors.New("The message destination does not match the device")
+	ErrorInvalidWRPSource             = errors.New("The message source is not valid for the device")
 )
+
+// RetryError is returned by a Connector when a device is turned away before its connection is upgraded.
+// RetryAfter is the suggested delay before the device reconnects, which ConnectHandler sends in a Retry-After header.
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (re *RetryError) Error() string {
+	return re.Err.Error()
+}
+
+func (re *RetryError) Unwrap() error {
+	return re.Err
+}
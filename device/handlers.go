@@ -2,12 +2,19 @@ package device
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ugorji/go/codec"
 	"github.com/xmidt-org/sallust"
 	"github.com/xmidt-org/webpa-common/v2/xhttp"
 	"github.com/xmidt-org/wrp-go/v3"
@@ -17,6 +24,16 @@ import (
 const (
 	DefaultMessageTimeout time.Duration = 2 * time.Minute
 	DefaultListRefresh    time.Duration = 10 * time.Second
+
+	// DefaultListLimit is the page size used by ListHandler when a cursor is supplied without a limit
+	DefaultListLimit = 100
+
+	// ListLimitParameter is the query parameter that sets the maximum number of devices in a ListHandler page
+	ListLimitParameter = "limit"
+
+	// ListCursorParameter is the query parameter holding the ListHandler cursor.  A page contains only
+	// devices whose IDs sort after the cursor.  The cursor for the next page is returned as "next".
+	ListCursorParameter = "cursor"
 )
 
 // IDFromRequest is a strategy type for extracting the device identifier from an HTTP request
@@ -168,6 +185,10 @@ func (mh *MessageHandler) ServeHTTP(httpResponse http.ResponseWriter, httpReques
 			code = http.StatusBadRequest
 		case ErrorTransactionAlreadyRegistered:
 			code = http.StatusBadRequest
+		case ErrorDestinationMismatch:
+			code = http.StatusBadRequest
+		case ErrorDeviceQueueFull:
+			code = http.StatusServiceUnavailable
 		}
 
 		mh.logger().Error("Could not process device request", zap.Error(err), zap.Int("code", code))
@@ -204,20 +225,43 @@ func (ch *ConnectHandler) logger() *zap.Logger {
 	return sallust.Default()
 }
 
+// setRetryAfter sets the Retry-After header to the given delay, rounded up to whole seconds
+func setRetryAfter(response http.ResponseWriter, d time.Duration) {
+	response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
 func (ch *ConnectHandler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
 	if device, err := ch.Connector.Connect(response, request, ch.ResponseHeader); err != nil {
 		ch.logger().Error("Failed to connect device", zap.Error(err))
+
+		var retryErr *RetryError
+		if errors.As(err, &retryErr) {
+			code := http.StatusServiceUnavailable
+			if errors.Is(err, ErrorDeviceFilteredOut) {
+				code = http.StatusForbidden
+			}
+
+			setRetryAfter(response, retryErr.RetryAfter)
+			xhttp.WriteError(response, code, err)
+		}
 	} else {
 		ch.logger().Debug("Connected device", zap.String("id", string(device.ID())))
 	}
 }
 
+// listFlushInterval is the number of devices written between flushes when a ListHandler streams its output
+const listFlushInterval = 100
+
 // ListHandler is an HTTP handler which can take updated JSON device lists.
 type ListHandler struct {
 	Logger   *zap.Logger
 	Registry Registry
 	Refresh  time.Duration
 
+	// Streaming indicates that the device list is written directly to each response as the
+	// Registry is visited, rather than being built and cached in memory.  When true, Refresh is ignored.
+	Streaming bool
+
 	lock        sync.RWMutex
 	cacheExpiry time.Time
 	cache       bytes.Buffer
@@ -242,6 +286,43 @@ func (lh *ListHandler) _now() time.Time {
 	return time.Now()
 }
 
+// writeDevice writes the JSON representation of a single device to the given output
+func writeDevice(output io.Writer, d Interface) {
+	// nolint: typecheck
+	if data, err := d.MarshalJSON(); err != nil {
+		fmt.Fprintf(output, `{"id": "%s", "error": "%s"}`, d.ID(), err)
+	} else {
+		output.Write(data)
+	}
+}
+
+// writeDevices writes the JSON device list to the given output.  If supplied, the written
+// closure is invoked after each device is written.
+//
+// The devices are collected before anything is written, so that a slow output never holds up
+// the Registry, which can't add or remove devices while it is being visited.
+func (lh *ListHandler) writeDevices(output io.Writer, written func()) {
+	var devices []Interface
+	lh.Registry.VisitAll(func(d Interface) bool {
+		devices = append(devices, d)
+		return true
+	})
+
+	io.WriteString(output, `{"devices":[`)
+	for i, d := range devices {
+		if i > 0 {
+			io.WriteString(output, `,`)
+		}
+
+		writeDevice(output, d)
+		if written != nil {
+			written()
+		}
+	}
+
+	io.WriteString(output, `]}`)
+}
+
 // tryCache returns the currently cache JSON bytes along with a flag indicating expiry.
 // This method returns true if the cached JSON bytes have expired, false otherwise.
 func (lh *ListHandler) tryCache() ([]byte, bool) {
@@ -257,40 +338,110 @@ func (lh *ListHandler) updateCache() []byte {
 
 	if lh.cacheExpiry.Before(lh._now()) {
 		lh.cache.Reset()
-		lh.cache.WriteString(`{"devices":[`)
+		lh.writeDevices(&lh.cache, nil)
+		lh.cacheBytes = lh.cache.Bytes()
+		lh.cacheExpiry = lh._now().Add(lh.refresh())
+	}
 
-		needsSeparator := false
-		lh.Registry.VisitAll(func(d Interface) bool {
-			if needsSeparator {
-				lh.cache.WriteString(`,`)
-			}
+	return lh.cacheBytes
+}
 
-			// nolint: typecheck
-			if data, err := d.MarshalJSON(); err != nil {
-				lh.cache.WriteString(
-					fmt.Sprintf(`{"id": "%s", "error": "%s"}`, d.ID(), err),
-				)
-			} else {
-				lh.cache.Write(data)
-			}
+// streamDevices writes the device list directly to the response, periodically flushing
+// if the response supports it.
+func (lh *ListHandler) streamDevices(response http.ResponseWriter) {
+	var (
+		flusher, canFlush = response.(http.Flusher)
+		count             int
+	)
+
+	lh.writeDevices(response, func() {
+		count++
+		if canFlush && count%listFlushInterval == 0 {
+			flusher.Flush()
+		}
+	})
+}
 
-			needsSeparator = true
-			return true
-		})
+// pageParameters extracts the pagination query parameters from a request.  The returned
+// flag indicates whether the request asked for a page rather than the complete list.
+func pageParameters(request *http.Request) (cursor ID, limit int, paginate bool, err error) {
+	query := request.URL.Query()
+	cursor = ID(query.Get(ListCursorParameter))
+	limit = DefaultListLimit
+	paginate = query.Has(ListCursorParameter)
+
+	if query.Has(ListLimitParameter) {
+		paginate = true
+		limit, err = strconv.Atoi(query.Get(ListLimitParameter))
+		if err == nil && limit < 1 {
+			err = fmt.Errorf("the %s parameter must be positive", ListLimitParameter)
+		}
+	}
 
-		lh.cache.WriteString(`]}`)
-		lh.cacheBytes = lh.cache.Bytes()
-		lh.cacheExpiry = lh._now().Add(lh.refresh())
+	return
+}
+
+// writePage writes the devices whose IDs sort after the cursor, up to the given limit.  When
+// more devices remain, the ID of the last device in the page is written as the next cursor.
+func (lh *ListHandler) writePage(output io.Writer, cursor ID, limit int) {
+	var page []Interface
+	lh.Registry.VisitAll(func(d Interface) bool {
+		if d.ID() > cursor {
+			page = append(page, d)
+		}
+
+		return true
+	})
+
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].ID() < page[j].ID()
+	})
+
+	var next ID
+	if len(page) > limit {
+		page = page[:limit]
+		next = page[limit-1].ID()
 	}
 
-	return lh.cacheBytes
+	io.WriteString(output, `{"devices":[`)
+	for i, d := range page {
+		if i > 0 {
+			io.WriteString(output, `,`)
+		}
+
+		writeDevice(output, d)
+	}
+
+	io.WriteString(output, `]`)
+	if len(next) > 0 {
+		nextJSON, _ := json.Marshal(next)
+		fmt.Fprintf(output, `,"next":%s`, nextJSON)
+	}
+
+	io.WriteString(output, `}`)
 }
 
 func (lh *ListHandler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
 	lh.Logger.Debug("ServeHTTP", zap.String("handler", "ListHandler"))
-	response.Header().Set("Content-Type", "application/json")
+	cursor, limit, paginate, err := pageParameters(request)
+	if err != nil {
+		lh.Logger.Error("invalid pagination parameters", zap.Error(err))
+		xhttp.WriteErrorf(
+			response,
+			http.StatusBadRequest,
+			"Invalid pagination parameters: %s",
+			err,
+		)
 
-	if cacheBytes, expired := lh.tryCache(); expired {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	if paginate {
+		lh.writePage(response, cursor, limit)
+	} else if lh.Streaming {
+		lh.streamDevices(response)
+	} else if cacheBytes, expired := lh.tryCache(); expired {
 		response.Write(lh.updateCache())
 	} else {
 		response.Write(cacheBytes)
@@ -342,6 +493,35 @@ func (sh *StatHandler) ServeHTTP(response http.ResponseWriter, request *http.Req
 		return
 	}
 
-	response.Header().Set("Content-Type", "application/json")
+	// nolint: typecheck
+	format, err := wrp.FormatFromContentType(request.Header.Get("Accept"), wrp.JSON)
+	if err != nil {
+		// unrecognized Accept values, such as wildcards, get the default JSON output
+		// nolint: typecheck
+		format = wrp.JSON
+	}
+
+	// nolint: typecheck
+	if format == wrp.Msgpack {
+		if data, err = transcodeStatistics(data); err != nil {
+			sh.Logger.Error("unable to transcode device statistics to msgpack", zap.Error(err), zap.String("deviceName", name))
+			response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response.Header().Set("Content-Type", format.ContentType())
 	response.Write(data)
 }
+
+// transcodeStatistics converts a device's JSON representation into msgpack
+func transcodeStatistics(data []byte) ([]byte, error) {
+	var statistics map[string]interface{}
+	if err := json.Unmarshal(data, &statistics); err != nil {
+		return nil, err
+	}
+
+	var output []byte
+	err := codec.NewEncoderBytes(&output, new(codec.MsgpackHandle)).Encode(statistics)
+	return output, err
+}
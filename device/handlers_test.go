@@ -7,6 +7,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,8 +18,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
 	"github.com/xmidt-org/sallust"
 	"github.com/xmidt-org/wrp-go/v3"
+	"go.uber.org/zap"
 )
 
 func testUseIDFNilStrategy(t *testing.T) {
@@ -258,6 +263,53 @@ func testMessageHandlerServeHTTPRouteError(t *testing.T, routeError error, expec
 	router.AssertExpectations(t)
 }
 
+// deviceRouter routes every request to a single device
+type deviceRouter struct {
+	d *device
+}
+
+func (dr deviceRouter) Route(request *Request) (*Response, error) {
+	return dr.d.Send(request)
+}
+
+func testMessageHandlerServeHTTPQueueFull(t *testing.T) {
+	var (
+		assert      = assert.New(t)
+		require     = require.New(t)
+		d, first, _ = newOverflowTestDevice(t, QueueOverflowDropNewest)
+
+		// nolint: typecheck
+		message = &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "test.com",
+			Destination: string(d.ID()),
+		}
+
+		requestContents []byte
+	)
+
+	defer func() {
+		d.requestClose(CloseReason{Text: "test"})
+		assert.Equal(ErrorDeviceClosed, <-first)
+	}()
+
+	// nolint: typecheck
+	require.NoError(wrp.NewEncoderBytes(&requestContents, wrp.Msgpack).Encode(message))
+
+	var (
+		response = httptest.NewRecorder()
+		request  = httptest.NewRequest("POST", "/foo", bytes.NewReader(requestContents))
+		handler  = MessageHandler{
+			Router: deviceRouter{d},
+		}
+	)
+
+	handler.ServeHTTP(response, request)
+	assert.Equal(http.StatusServiceUnavailable, response.Code)
+	assert.Equal(ErrorDeviceQueueFull.Error(), response.Header().Get("X-Xmidt-Message-Error"))
+	assert.Equal(1, d.Pending())
+}
+
 // nolint: typecheck
 func testMessageHandlerServeHTTPEvent(t *testing.T, requestFormat wrp.Format) {
 	var (
@@ -486,12 +538,14 @@ func TestMessageHandler(t *testing.T) {
 		t.Run("DecodeError", testMessageHandlerServeHTTPDecodeError)
 		t.Run("EncodeError", testMessageHandlerServeHTTPEncodeError)
 
+		t.Run("QueueFull", testMessageHandlerServeHTTPQueueFull)
 		t.Run("RouteError", func(t *testing.T) {
 			testMessageHandlerServeHTTPRouteError(t, ErrorInvalidDeviceName, http.StatusBadRequest)
 			testMessageHandlerServeHTTPRouteError(t, ErrorDeviceNotFound, http.StatusNotFound)
 			testMessageHandlerServeHTTPRouteError(t, ErrorNonUniqueID, http.StatusBadRequest)
 			testMessageHandlerServeHTTPRouteError(t, ErrorInvalidTransactionKey, http.StatusBadRequest)
 			testMessageHandlerServeHTTPRouteError(t, ErrorTransactionAlreadyRegistered, http.StatusBadRequest)
+			testMessageHandlerServeHTTPRouteError(t, ErrorDeviceQueueFull, http.StatusServiceUnavailable)
 			testMessageHandlerServeHTTPRouteError(t, errors.New("random error"), http.StatusGatewayTimeout)
 		})
 
@@ -565,8 +619,37 @@ func testConnectHandlerServeHTTP(t *testing.T, connectError error, responseHeade
 	connector.AssertExpectations(t)
 }
 
+func testConnectHandlerRetryError(t *testing.T, connectError error, expectedCode int, expectedRetryAfter string) {
+	var (
+		assert = assert.New(t)
+
+		connector = new(MockConnector)
+		handler   = ConnectHandler{
+			Connector: connector,
+		}
+
+		response = httptest.NewRecorder()
+		request  = httptest.NewRequest("GET", "/", nil)
+	)
+
+	// nolint: typecheck
+	connector.On("Connect", response, request, http.Header(nil)).Once().Return(nil, connectError)
+
+	handler.ServeHTTP(response, request)
+	assert.Equal(expectedCode, response.Code)
+	assert.Equal(expectedRetryAfter, response.Header().Get("Retry-After"))
+
+	// nolint: typecheck
+	connector.AssertExpectations(t)
+}
+
 func TestConnectHandler(t *testing.T) {
 	t.Run("Logger", testConnectHandlerLogger)
+	t.Run("RetryError", func(t *testing.T) {
+		testConnectHandlerRetryError(t, &RetryError{Err: ErrorDeviceFilteredOut, RetryAfter: 30 * time.Second}, http.StatusForbidden, "30")
+		testConnectHandlerRetryError(t, &RetryError{Err: errDeviceLimitReached, RetryAfter: 1500 * time.Millisecond}, http.StatusServiceUnavailable, "2")
+		testConnectHandlerRetryError(t, &RetryError{Err: ErrorConnectRateLimited, RetryAfter: 30 * time.Second}, http.StatusServiceUnavailable, "30")
+	})
 	t.Run("ServeHTTP", func(t *testing.T) {
 		testConnectHandlerServeHTTP(t, nil, nil)
 		testConnectHandlerServeHTTP(t, nil, http.Header{"Header-1": []string{"Value-1"}})
@@ -701,9 +784,252 @@ func testListHandlerServeHTTP(t *testing.T) {
 	registry.AssertExpectations(t)
 }
 
+func testListHandlerServeHTTPStreaming(t *testing.T) {
+	var (
+		assert              = assert.New(t)
+		require             = require.New(t)
+		expectedConnectedAt = time.Now().UTC()
+		expectedUpTime      = 47913 * time.Minute
+		registry            = new(MockRegistry)
+		logger              = sallust.Default()
+
+		now = func() time.Time {
+			return expectedConnectedAt.Add(expectedUpTime)
+		}
+
+		devices = []*device{
+			newDevice(deviceOptions{ID: ID("first"), QueueSize: 1, ConnectedAt: expectedConnectedAt, Logger: logger}),
+			newDevice(deviceOptions{ID: ID("second"), QueueSize: 1, ConnectedAt: expectedConnectedAt, Logger: logger}),
+			newDevice(deviceOptions{ID: ID("third"), QueueSize: 1, ConnectedAt: expectedConnectedAt, Logger: logger}),
+		}
+
+		cached = ListHandler{
+			Logger:   logger,
+			Registry: registry,
+		}
+
+		streaming = ListHandler{
+			Logger:    logger,
+			Registry:  registry,
+			Streaming: true,
+		}
+	)
+
+	for _, d := range devices {
+		d.statistics = NewStatistics(now, expectedConnectedAt)
+	}
+
+	// nolint: typecheck
+	registry.On("VisitAll", mock.MatchedBy(func(func(Interface) bool) bool { return true })).
+		Run(func(arguments mock.Arguments) {
+			visitor := arguments.Get(0).(func(Interface) bool)
+			for _, d := range devices {
+				visitor(d)
+			}
+		}).
+		Return(len(devices)).Twice()
+
+	cachedResponse := httptest.NewRecorder()
+	cached.ServeHTTP(cachedResponse, httptest.NewRequest("GET", "/", nil))
+	require.Equal(http.StatusOK, cachedResponse.Code)
+
+	streamingResponse := httptest.NewRecorder()
+	streaming.ServeHTTP(streamingResponse, httptest.NewRequest("GET", "/", nil))
+	require.Equal(http.StatusOK, streamingResponse.Code)
+
+	assert.Equal("application/json", streamingResponse.Header().Get("Content-Type"))
+	assert.Equal(cachedResponse.Body.String(), streamingResponse.Body.String())
+	assert.True(streaming.cacheExpiry.IsZero())
+	assert.Empty(streaming.cacheBytes)
+
+	// nolint: typecheck
+	registry.AssertExpectations(t)
+}
+
+func testListHandlerServeHTTPPaginated(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		registry = new(MockRegistry)
+		logger   = sallust.Default()
+
+		devices = []*device{
+			newDevice(deviceOptions{ID: ID("mac:000000000004"), QueueSize: 1, Logger: logger}),
+			newDevice(deviceOptions{ID: ID("mac:000000000002"), QueueSize: 1, Logger: logger}),
+			newDevice(deviceOptions{ID: ID("mac:000000000005"), QueueSize: 1, Logger: logger}),
+			newDevice(deviceOptions{ID: ID("mac:000000000001"), QueueSize: 1, Logger: logger}),
+			newDevice(deviceOptions{ID: ID("mac:000000000003"), QueueSize: 1, Logger: logger}),
+		}
+
+		handler = ListHandler{
+			Logger:   logger,
+			Registry: registry,
+		}
+
+		seen   []string
+		cursor string
+		pages  int
+	)
+
+	// nolint: typecheck
+	registry.On("VisitAll", mock.MatchedBy(func(func(Interface) bool) bool { return true })).
+		Run(func(arguments mock.Arguments) {
+			visitor := arguments.Get(0).(func(Interface) bool)
+			for _, d := range devices {
+				visitor(d)
+			}
+		}).
+		Return(len(devices))
+
+	for {
+		target := "/?limit=2"
+		if len(cursor) > 0 {
+			target += "&cursor=" + url.QueryEscape(cursor)
+		}
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("GET", target, nil))
+		require.Equal(http.StatusOK, response.Code)
+		pages++
+
+		var page struct {
+			Devices []struct {
+				ID string `json:"id"`
+			} `json:"devices"`
+			Next string `json:"next"`
+		}
+
+		require.NoError(json.Unmarshal(response.Body.Bytes(), &page))
+		assert.LessOrEqual(len(page.Devices), 2)
+		for _, d := range page.Devices {
+			seen = append(seen, d.ID)
+		}
+
+		if len(page.Next) == 0 {
+			break
+		}
+
+		require.Less(pages, len(devices), "pagination did not terminate")
+		cursor = page.Next
+	}
+
+	assert.Equal(3, pages)
+	assert.Equal(
+		[]string{"mac:000000000001", "mac:000000000002", "mac:000000000003", "mac:000000000004", "mac:000000000005"},
+		seen,
+	)
+
+	// paginated requests never populate the cache
+	assert.True(handler.cacheExpiry.IsZero())
+}
+
+func testListHandlerServeHTTPBadLimit(t *testing.T) {
+	for _, limit := range []string{"0", "-1", "abc"} {
+		t.Run(limit, func(t *testing.T) {
+			var (
+				assert   = assert.New(t)
+				registry = new(MockRegistry)
+				handler  = ListHandler{
+					Logger:   sallust.Default(),
+					Registry: registry,
+				}
+
+				response = httptest.NewRecorder()
+			)
+
+			handler.ServeHTTP(response, httptest.NewRequest("GET", "/?limit="+limit, nil))
+			assert.Equal(http.StatusBadRequest, response.Code)
+
+			// nolint: typecheck
+			registry.AssertExpectations(t)
+		})
+	}
+}
+
+// blockingResponseWriter holds up the first device written until it is released
+type blockingResponseWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (brw *blockingResponseWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte(`"id"`)) {
+		brw.once.Do(func() {
+			close(brw.writing)
+			<-brw.release
+		})
+	}
+
+	return brw.ResponseRecorder.Write(p)
+}
+
+func testListHandlerServeHTTPStreamingUnlocked(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(&Options{Logger: zap.NewNop()}).(*manager)
+
+		handler = ListHandler{
+			Logger:    zap.NewNop(),
+			Registry:  m,
+			Streaming: true,
+		}
+
+		response = &blockingResponseWriter{
+			ResponseRecorder: httptest.NewRecorder(),
+			writing:          make(chan struct{}),
+			release:          make(chan struct{}),
+		}
+
+		served = make(chan struct{})
+		added  = make(chan error, 1)
+	)
+
+	require.NoError(m.devices.add(newDevice(deviceOptions{ID: ID("first"), Logger: zap.NewNop()})))
+
+	go func() {
+		defer close(served)
+		handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	}()
+
+	<-response.writing
+
+	// a stalled client must not keep devices from connecting
+	go func() {
+		added <- m.devices.add(newDevice(deviceOptions{ID: ID("second"), Logger: zap.NewNop()}))
+	}()
+
+	select {
+	case err := <-added:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("The registry was locked while the response was written")
+	}
+
+	close(response.release)
+	<-served
+
+	// the list reflects the registry as of the visit
+	var list struct {
+		Devices []struct {
+			ID string `json:"id"`
+		} `json:"devices"`
+	}
+
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &list))
+	require.Len(list.Devices, 1)
+	assert.Equal("first", list.Devices[0].ID)
+}
+
 func TestListHandler(t *testing.T) {
 	t.Run("Refresh", testListHandlerRefresh)
 	t.Run("ServeHTTP", testListHandlerServeHTTP)
+	t.Run("ServeHTTPStreaming", testListHandlerServeHTTPStreaming)
+	t.Run("ServeHTTPStreamingUnlocked", testListHandlerServeHTTPStreamingUnlocked)
+	t.Run("ServeHTTPPaginated", testListHandlerServeHTTPPaginated)
+	t.Run("ServeHTTPBadLimit", testListHandlerServeHTTPBadLimit)
 }
 
 func testStatHandlerNoPathVariables(t *testing.T) {
@@ -862,6 +1188,76 @@ func testStatHandlerSuccess(t *testing.T) {
 	device.AssertExpectations(t)
 }
 
+func testStatHandlerAccept(t *testing.T) {
+	testData := []struct {
+		accept              string
+		expectedContentType string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/json", "application/json"},
+		{"application/msgpack", "application/msgpack"},
+	}
+
+	for _, record := range testData {
+		t.Run(record.accept, func(t *testing.T) {
+			var (
+				assert   = assert.New(t)
+				require  = require.New(t)
+				registry = new(MockRegistry)
+				device   = new(MockDevice)
+
+				handler = StatHandler{
+					Logger:   sallust.Default(),
+					Registry: registry,
+					Variable: "deviceID",
+				}
+
+				expected = map[string]interface{}{
+					"id":         "mac:112233445566",
+					"pending":    float64(3),
+					"statistics": map[string]interface{}{"bytesSent": float64(12), "upTime": "1h0m0s"},
+				}
+
+				router   = mux.NewRouter()
+				request  = httptest.NewRequest("GET", "/mac:112233445566", nil)
+				response = httptest.NewRecorder()
+			)
+
+			deviceJSON, err := json.Marshal(expected)
+			require.NoError(err)
+
+			request.Header.Set("Accept", record.accept)
+			router.Handle("/{deviceID}", &handler)
+			// nolint: typecheck
+			registry.On("Get", ID("mac:112233445566")).Return(device, true).Once()
+			// nolint: typecheck
+			device.On("MarshalJSON").Return(deviceJSON, (error)(nil)).Once()
+
+			router.ServeHTTP(response, request)
+			assert.Equal(http.StatusOK, response.Code)
+			assert.Equal(record.expectedContentType, response.Header().Get("Content-Type"))
+
+			var actual map[string]interface{}
+			if record.expectedContentType == "application/msgpack" {
+				handle := new(codec.MsgpackHandle)
+				handle.RawToString = true
+				handle.MapType = reflect.TypeOf(map[string]interface{}(nil))
+				require.NoError(codec.NewDecoderBytes(response.Body.Bytes(), handle).Decode(&actual))
+			} else {
+				require.NoError(json.Unmarshal(response.Body.Bytes(), &actual))
+			}
+
+			assert.Equal(expected, actual)
+
+			// nolint: typecheck
+			registry.AssertExpectations(t)
+			// nolint: typecheck
+			device.AssertExpectations(t)
+		})
+	}
+}
+
 func TestStatHandler(t *testing.T) {
 	t.Run("NoPathVariables", testStatHandlerNoPathVariables)
 	t.Run("NoDeviceName", testStatHandlerNoDeviceName)
@@ -869,4 +1265,5 @@ func TestStatHandler(t *testing.T) {
 	t.Run("MissingDevice", testStatHandlerMissingDevice)
 	t.Run("MarshalJSONFailed", testStatHandlerMarshalJSONFailed)
 	t.Run("Success", testStatHandlerSuccess)
+	t.Run("Accept", testStatHandlerAccept)
 }
@@ -2,6 +2,7 @@ package device
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
@@ -23,6 +24,7 @@ const (
 	macDelimiters = ":-.,"
 	macPrefix     = "mac"
 	macLength     = 12
+	ipPrefix      = "ip"
 )
 
 var (
@@ -31,7 +33,7 @@ var (
 	// idPattern is the precompiled regular expression that all device identifiers must match.
 	// Matching is partial, as everything after the service is ignored.
 	idPattern = regexp.MustCompile(
-		`^(?P<prefix>(?i)mac|uuid|dns|serial):(?P<id>[^/]+)(?P<service>/[^/]+)?`,
+		`^(?P<prefix>(?i)mac|uuid|dns|serial|ip):(?P<id>[^/]+)(?P<service>/[^/]+)?`,
 	)
 )
 
@@ -54,7 +56,8 @@ func ParseID(deviceName string) (ID, error) {
 		idPart = match[2]
 	)
 
-	if prefix == macPrefix {
+	switch prefix {
+	case macPrefix:
 		var invalidCharacter rune = -1
 		idPart = strings.Map(
 			func(r rune) rune {
@@ -74,6 +77,15 @@ func ParseID(deviceName string) (ID, error) {
 		if invalidCharacter != -1 || len(idPart) != macLength {
 			return invalidID, ErrorInvalidDeviceName
 		}
+
+	case ipPrefix:
+		// both IPv4 and IPv6 literals are allowed, and are normalized to their canonical form
+		ip := net.ParseIP(idPart)
+		if ip == nil {
+			return invalidID, ErrorInvalidDeviceName
+		}
+
+		idPart = ip.String()
 	}
 
 	return ID(fmt.Sprintf("%s:%s", prefix, idPart)), nil
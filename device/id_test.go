@@ -49,6 +49,14 @@ func TestParseID(t *testing.T) {
 		{"MAC:invalid45566", "", true},
 		{"mac:481d70187fef", "mac:481d70187fef", false},
 		{"mac:481d70187fef/parodus/tag/test0", "mac:481d70187fef", false},
+		{"ip:192.168.1.10", "ip:192.168.1.10", false},
+		{"IP:192.168.1.10/service", "ip:192.168.1.10", false},
+		{"ip:2001:db8::1", "ip:2001:db8::1", false},
+		{"ip:2001:DB8:0:0:0:0:0:1/service/ignoreMe", "ip:2001:db8::1", false},
+		{"ip:192.168.1", "", true},
+		{"ip:192.168.1.256", "", true},
+		{"ip:2001:db8:::1", "", true},
+		{"ip:not-an-address", "", true},
 	}
 
 	for _, record := range testData {
@@ -36,6 +36,14 @@ const (
 	// was no waiting transaction
 	TransactionBroken
 
+	// QueueHighWater indicates that the number of messages waiting to be sent to a device has
+	// reached the configured high-water mark.  It is not dispatched again until the queue recovers.
+	QueueHighWater
+
+	// QueueRecovered indicates that a device's message queue has receded below the high-water
+	// mark after a QueueHighWater event.
+	QueueRecovered
+
 	InvalidEventString string = "!!INVALID DEVICE EVENT TYPE!!"
 )
 
@@ -55,6 +63,10 @@ func (et EventType) String() string {
 		return "TransactionComplete"
 	case TransactionBroken:
 		return "TransactionBroken"
+	case QueueHighWater:
+		return "QueueHighWater"
+	case QueueRecovered:
+		return "QueueRecovered"
 	default:
 		return InvalidEventString
 	}
@@ -96,6 +108,10 @@ type Event struct {
 	// for MessageFailed events when there was an actual error.  For MessageFailed events that indicate a
 	// device was disconnected with enqueued messages, this field will be nil.
 	Error error
+
+	// QueueDepth is the number of messages waiting to be sent to the device.  This field is only
+	// populated for QueueHighWater and QueueRecovered events.
+	QueueDepth int
 }
 
 // Listener is an event sink.  Listeners should never modify events and should never
@@ -18,6 +18,8 @@ func testEventString(t *testing.T) {
 			MessageFailed,
 			TransactionComplete,
 			TransactionBroken,
+			QueueHighWater,
+			QueueRecovered,
 		}
 	)
 
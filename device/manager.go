@@ -1,13 +1,16 @@
 package device
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/xmidt-org/webpa-common/v2/convey"
@@ -47,7 +50,8 @@ type Connector interface {
 	Disconnect(ID, CloseReason) bool
 
 	// DisconnectIf iterates over all devices known to this manager, applying the
-	// given predicate.  For any devices that result in true, this method disconnects them.
+	// given predicate.  For any devices that result in true, this method disconnects them
+	// using the CloseReason returned by the predicate for that device.
 	// Note that this method may pause connections and disconnections while it is executing.
 	// This method returns the number of devices that were disconnected.
 	//
@@ -83,11 +87,19 @@ type Registry interface {
 	// Get returns the device associated with the given ID, if any
 	Get(ID) (Interface, bool)
 
+	// GetMany returns the devices associated with each of the given IDs.  IDs that are
+	// not connected are absent from the returned map.  The returned map is never nil.
+	GetMany([]ID) map[ID]Interface
+
 	// VisitAll applies the given visitor function to each device known to this manager.
 	//
 	// No methods on this Manager should be called from within the visitor function, or
 	// a deadlock will likely occur.
 	VisitAll(func(Interface) bool) int
+
+	// VisitAllCtx is like VisitAll, except that iteration stops once the context is done.
+	// The returned count is the number of devices visited before iteration stopped.
+	VisitAllCtx(context.Context, func(Interface) bool) int
 }
 
 type Filter interface {
@@ -97,6 +109,10 @@ type Filter interface {
 type MatchResult struct {
 	Location string
 	Key      string
+
+	// Pattern is the filter pattern that matched, for filters that match values against patterns
+	// rather than exact values.  This field is empty for exact matches.
+	Pattern string
 }
 
 type FilterFunc func(d Interface) (bool, MatchResult)
@@ -112,6 +128,17 @@ type Manager interface {
 	Router
 	Registry
 	MaxDevices() int
+
+	// UpdateMetadata applies the given function to the Metadata of the device with the given ID,
+	// returning true if the device was found.  The update is applied under the registry's write lock,
+	// so the function must not call any methods on this Manager.  Any metrics derived from the
+	// device's metadata are refreshed after the update.
+	UpdateMetadata(ID, func(*Metadata)) bool
+
+	// AddListener registers a listener for device events in addition to any listeners supplied
+	// via Options.  The returned closure removes the listener and is idempotent.  Listeners may
+	// be added or removed concurrently with event dispatch, including from within a listener.
+	AddListener(Listener) (cancel func())
 }
 
 // ManagerOption is a configuration option for a manager
@@ -133,29 +160,39 @@ func NewManager(o *Options) Manager {
 		readDeadline:     NewDeadline(o.idlePeriod(), o.now()),
 		writeDeadline:    NewDeadline(o.writeTimeout(), o.now()),
 		upgrader:         o.upgrader(),
-		conveyTranslator: conveyhttp.NewHeaderTranslator("", nil),
+		conveyTranslator: o.conveyTranslator(),
 		devices: newRegistry(registryOptions{
 			Logger:   logger,
 			Limit:    o.maxDevices(),
 			Measures: measures,
 		}),
-		conveyHWMetric: conveymetric.NewConveyMetric(measures.Models, []conveymetric.TagLabelPair{
-			{
-				Tag:   "hw-model",
-				Label: "model",
-			},
-			{
-				Tag:   "fw-name",
-				Label: "firmware",
-			}}...),
+		conveyHWMetric: conveymetric.NewConveyMetric(measures.Models, o.conveyMetricPairs()...),
 
 		deviceMessageQueueSize: o.deviceMessageQueueSize(),
+		queueOverflowPolicy:    o.queueOverflowPolicy(),
+		queueHighWater:         o.queueHighWater(),
+		framePool:              o.framePool(),
 		pingPeriod:             o.pingPeriod(),
+		pingJitter:             o.pingJitter(),
+		pingPayload:            o.pingPayload(),
+		random:                 rand.Float64,
+		compression:            o.compression(),
+		compressionLevel:       o.compressionLevel(),
+		allowTextFrames:        o.allowTextFrames(),
+		subprotocols:           o.subprotocols(),
+		reportConveyCompliance: o.reportConveyCompliance(),
+		rejectRetryAfter:       o.rejectRetryAfter(),
 
 		listeners:             o.listeners(),
 		measures:              measures,
 		enforceWRPSourceCheck: wrpCheck.Type == CheckTypeEnforce,
+		enforceWRPDestination: o.enforceWRPDestination(),
+		rejectEmptyWRPSource:  o.rejectEmptyWRPSource(),
 		filter:                o.filter(),
+		connectLimiter:        o.connectLimiter(),
+		now:                   o.now(),
+
+		respondOnWRPSourceReject: wrpCheck.Type == CheckTypeEnforce && wrpCheck.RespondOnReject,
 	}
 }
 
@@ -172,17 +209,49 @@ type manager struct {
 	conveyHWMetric conveymetric.Interface
 
 	deviceMessageQueueSize int
+	queueOverflowPolicy    QueueOverflowPolicy
+	queueHighWater         int
+	framePool              *sync.Pool
 	pingPeriod             time.Duration
+	pingJitter             float64
+	pingPayload            []byte
+	compression            bool
+	compressionLevel       int
+	allowTextFrames        bool
+	subprotocols           []string
+	reportConveyCompliance bool
+	rejectRetryAfter       time.Duration
 
 	listeners             []Listener
 	measures              Measures
 	enforceWRPSourceCheck bool
+	enforceWRPDestination bool
+	rejectEmptyWRPSource  bool
+
+	// respondOnWRPSourceReject tells devices when the source check drops one of their transaction messages
+	respondOnWRPSourceReject bool
 
-	filter Filter
+	filter         Filter
+	connectLimiter *connectLimiter
+
+	// addedListeners holds the listeners registered with AddListener.  The slice is never modified
+	// in place, so that dispatch can iterate over it without holding the lock.
+	listenerLock   sync.RWMutex
+	addedListeners []*addedListener
+
+	// now is the clock used to measure device sessions
+	now func() time.Time
+
+	// random returns a pseudo-random number in [0.0, 1.0) and is used to jitter ping periods
+	random func() float64
 }
 
 func (m *manager) Connect(response http.ResponseWriter, request *http.Request, responseHeader http.Header) (Interface, error) {
 	m.logger.Debug("device connect", zap.Any("url", request.URL))
+	if !m.connectLimiter.allow() {
+		return nil, &RetryError{Err: ErrorConnectRateLimited, RetryAfter: m.rejectRetryAfter}
+	}
+
 	ctx := request.Context()
 	id, ok := GetID(ctx)
 	if !ok {
@@ -201,18 +270,45 @@ func (m *manager) Connect(response http.ResponseWriter, request *http.Request, r
 	}
 
 	cvy, cvyErr := m.conveyTranslator.FromHeader(request.Header)
+	compliance := convey.GetCompliance(cvyErr)
+	if m.reportConveyCompliance {
+		// the supplied response header can be shared across connections, so modify a copy
+		responseHeader = responseHeader.Clone()
+		if responseHeader == nil {
+			responseHeader = make(http.Header, 1)
+		}
+
+		responseHeader.Set(ConveyComplianceHeader, compliance.String())
+		response.Header().Set(ConveyComplianceHeader, compliance.String())
+	}
+
 	d := newDevice(deviceOptions{
-		ID:         id,
-		C:          cvy,
-		Compliance: convey.GetCompliance(cvyErr),
-		QueueSize:  m.deviceMessageQueueSize,
-		Metadata:   metadata,
-		Logger:     m.logger,
+		ID:          id,
+		C:           cvy,
+		Compliance:  compliance,
+		QueueSize:   m.deviceMessageQueueSize,
+		ConnectedAt: m.now(),
+		Metadata:    metadata,
+		Logger:      m.logger,
+
+		QueueOverflowPolicy: m.queueOverflowPolicy,
+		Dropped:             m.messageDropped,
+		EnforceDestination:  m.enforceWRPDestination,
+		QueueHighWater:      m.queueHighWater,
+		QueueCrossed:        m.queueCrossed,
 	})
 
 	if allow, matchResults := m.filter.AllowConnection(d); !allow {
-		d.logger.Info("filter match found", zap.String("location", matchResults.Location), zap.String("key", matchResults.Key))
-		return nil, ErrorDeviceFilteredOut
+		d.logger.Info("filter match found", zap.String("location", matchResults.Location), zap.String("key", matchResults.Key), zap.String("pattern", matchResults.Pattern))
+		return nil, &RetryError{Err: ErrorDeviceFilteredOut, RetryAfter: m.rejectRetryAfter}
+	}
+
+	// check the device limit prior to upgrading, so that the device can be told when to retry.
+	// registry.add still enforces the limit, since other devices may connect concurrently.
+	if m.devices.full(id) {
+		d.logger.Error("device limit reached", zap.Int("maxDevices", m.devices.limit))
+		m.devices.limitReached.Inc()
+		return nil, &RetryError{Err: errDeviceLimitReached, RetryAfter: m.rejectRetryAfter}
 	}
 
 	if len(metadata.Claims()) < 1 {
@@ -225,23 +321,44 @@ func (m *manager) Connect(response http.ResponseWriter, request *http.Request, r
 		d.logger.Error("bad or missing convey data", zap.Error(cvyErr))
 	}
 
+	if !m.supportsSubprotocol(request) {
+		d.logger.Error("unsupported websocket subprotocols", zap.Strings("subprotocols", websocket.Subprotocols(request)))
+		xhttp.WriteError(response, http.StatusBadRequest, ErrorUnsupportedSubprotocol)
+		return nil, ErrorUnsupportedSubprotocol
+	}
+
 	c, err := m.upgrader.Upgrade(response, request, responseHeader)
 	if err != nil {
 		d.logger.Error("failed websocket upgrade", zap.Error(err))
 		return nil, err
 	}
 
-	d.logger.Debug("websocket upgrade complete", zap.String("localAddress", c.LocalAddr().String()))
+	d.logger.Debug("websocket upgrade complete", zap.String("localAddress", c.LocalAddr().String()), zap.String("subprotocol", c.Subprotocol()))
+
+	if m.compression {
+		if err := c.SetCompressionLevel(m.compressionLevel); err != nil {
+			d.logger.Error("unable to set compression level", zap.Error(err), zap.Int("compressionLevel", m.compressionLevel))
+		}
+	}
+
+	pingPayload := m.pingPayload
+	if pingPayload == nil {
+		pingPayload = []byte(d.ID())
+	}
 
-	pinger, err := NewPinger(c, m.measures.Ping, []byte(d.ID()), m.writeDeadline)
+	pinger, err := NewPinger(c, m.measures.Ping, pingPayload, m.writeDeadline)
 	if err != nil {
 		d.logger.Error("unable to create pinger", zap.Error(err))
 		c.Close()
 		return nil, err
 	}
 
+	// the convey metric closure must be in place before the device is visible in the registry,
+	// as UpdateMetadata replaces it
+	d.conveyClosure = m.updateConveyHWMetric(d)
 	if err := m.devices.add(d); err != nil {
 		d.logger.Error("unable to register device", zap.Error(err))
+		d.conveyClosure()
 		c.Close()
 		return nil, err
 	}
@@ -261,12 +378,7 @@ func (m *manager) Connect(response http.ResponseWriter, request *http.Request, r
 			d.logger.Error("unable to marshal the convey header", zap.Error(err))
 		}
 	}
-	metricClosure, err := m.conveyHWMetric.Update(cvy, "partnerid", metadata.PartnerIDClaim(), "trust", strconv.Itoa(metadata.TrustClaim()))
-	if err != nil {
-		d.logger.Error("failed to update convey metrics", zap.Error(err))
-	}
 
-	d.conveyClosure = metricClosure
 	m.dispatch(event)
 
 	SetPongHandler(c, m.measures.Pong, m.readDeadline)
@@ -279,10 +391,106 @@ func (m *manager) Connect(response http.ResponseWriter, request *http.Request, r
 	return d, nil
 }
 
+// supportsSubprotocol tests if one of the subprotocols requested by a device is supported.  A request with
+// no subprotocols is always supported, as is any request when no subprotocols were configured in Options.
+func (m *manager) supportsSubprotocol(request *http.Request) bool {
+	requested := websocket.Subprotocols(request)
+	if len(requested) == 0 || len(m.subprotocols) == 0 {
+		return true
+	}
+
+	for _, supported := range m.subprotocols {
+		for _, r := range requested {
+			if r == supported {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// updateConveyHWMetric updates the convey hardware gauge using the device's convey and current metadata,
+// returning the closure that reverses the update.
+func (m *manager) updateConveyHWMetric(d *device) conveymetric.Closure {
+	cvy, _ := d.c.(convey.C)
+	metadata := d.Metadata()
+	closure, err := m.conveyHWMetric.Update(cvy, "partnerid", metadata.PartnerIDClaim(), "trust", strconv.Itoa(metadata.TrustClaim()))
+	if err != nil {
+		d.logger.Error("failed to update convey metrics", zap.Error(err))
+		return func() {}
+	}
+
+	return closure
+}
+
+// addedListener wraps a Listener registered with AddListener, giving it an identity for removal
+type addedListener struct {
+	listener Listener
+}
+
+func (m *manager) AddListener(l Listener) func() {
+	al := &addedListener{listener: l}
+
+	m.listenerLock.Lock()
+	m.addedListeners = append(m.addedListeners[:len(m.addedListeners):len(m.addedListeners)], al)
+	m.listenerLock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			defer m.listenerLock.Unlock()
+			m.listenerLock.Lock()
+
+			remaining := make([]*addedListener, 0, len(m.addedListeners))
+			for _, existing := range m.addedListeners {
+				if existing != al {
+					remaining = append(remaining, existing)
+				}
+			}
+
+			m.addedListeners = remaining
+		})
+	}
+}
+
 func (m *manager) dispatch(e *Event) {
 	for _, listener := range m.listeners {
 		listener(e)
 	}
+
+	m.listenerLock.RLock()
+	added := m.addedListeners
+	m.listenerLock.RUnlock()
+
+	for _, al := range added {
+		al.listener(e)
+	}
+}
+
+// messageDropped dispatches a MessageFailed event for a message that was discarded
+// from a device's queue due to the QueueOverflowDropOldest policy.
+func (m *manager) messageDropped(d *device, e *envelope) {
+	d.logger.Error("dropped oldest message from full queue", zap.Any("deviceMessage", e))
+	m.dispatch(&Event{
+		Type:     MessageFailed,
+		Device:   d,
+		Message:  e.request.Message,
+		Format:   e.request.Format,
+		Contents: e.request.Contents,
+		Error:    ErrorDeviceQueueFull,
+	})
+}
+
+// queueCrossed dispatches a QueueHighWater or QueueRecovered event for a device whose
+// message queue crossed the high-water mark.
+func (m *manager) queueCrossed(d *device, eventType EventType, depth int) {
+	d.logger.Info("device queue crossed the high-water mark", zap.Stringer("event", eventType), zap.Int("queueDepth", depth))
+	m.dispatch(&Event{
+		Type:       eventType,
+		Device:     d,
+		QueueDepth: depth,
+	})
 }
 
 // pumpClose handles the proper shutdown and logging of a device's pumps.
@@ -300,6 +508,7 @@ func (m *manager) pumpClose(d *device, c io.Closer, reason CloseReason) {
 	}
 
 	closeError := c.Close()
+	m.measures.SessionDuration.Observe(m.now().Sub(d.Statistics().ConnectedAt()).Seconds())
 
 	d.logger.Error("Closed device connection",
 		zap.NamedError("closeError", closeError), zap.String("reasonError", reason.String()), zap.String("reason", reason.Text),
@@ -320,7 +529,7 @@ func (m *manager) wrpSourceIsValid(message *wrp.Message, d *device) bool {
 	expectedID := d.ID()
 	if len(strings.TrimSpace(message.Source)) == 0 {
 		d.logger.Error("WRP source was empty", zap.Int("trustLevel", d.Metadata().TrustClaim()))
-		if m.enforceWRPSourceCheck {
+		if m.enforceWRPSourceCheck || m.rejectEmptyWRPSource {
 			m.measures.WRPSourceCheck.With("outcome", "rejected", "reason", "empty").Add(1)
 			return false
 		}
@@ -353,6 +562,30 @@ func (m *manager) wrpSourceIsValid(message *wrp.Message, d *device) bool {
 	return true
 }
 
+// rejectWRPSource lets a device know that one of its transaction messages was dropped by the
+// enforced source check.  The response is queued without blocking the read pump and without
+// registering a transaction, so it is discarded if the device's queue is full.
+// nolint: typecheck
+func (m *manager) rejectWRPSource(d *device, message *wrp.Message) {
+	// nolint: typecheck
+	response := &wrp.Message{
+		Type:            message.Type,
+		Source:          message.Destination,
+		Destination:     string(d.id),
+		TransactionUUID: message.TransactionUUID,
+		ContentType:     "text/plain",
+		Payload:         []byte(ErrorInvalidWRPSource.Error()),
+	}
+
+	response.SetStatus(http.StatusForbidden)
+	select {
+	case d.messages <- &envelope{request: &Request{Message: response}, complete: make(chan error, 1)}:
+		d.checkQueueDepth()
+	default:
+		d.logger.Error("unable to queue WRP source rejection", zap.String("transactionKey", message.TransactionUUID))
+	}
+}
+
 // nolint: typecheck
 func addDeviceMetadataContext(message *wrp.Message, deviceMetadata *Metadata) {
 	if message.Metadata == nil {
@@ -377,7 +610,9 @@ func (m *manager) readPump(d *device, r ReadCloser, closeOnce *sync.Once) {
 	var (
 		readError error
 		// nolint: typecheck
-		decoder = wrp.NewDecoder(nil, wrp.Msgpack)
+		msgpackDecoder = wrp.NewDecoder(nil, wrp.Msgpack)
+		// nolint: typecheck
+		jsonDecoder = wrp.NewDecoder(nil, wrp.JSON)
 		// nolint: typecheck
 		encoder = wrp.NewEncoder(nil, wrp.Msgpack)
 	)
@@ -385,17 +620,38 @@ func (m *manager) readPump(d *device, r ReadCloser, closeOnce *sync.Once) {
 	// all the read pump has to do is ensure the device and the connection are closed
 	// it is the write pump's responsibility to do further cleanup
 	defer func() {
-		closeOnce.Do(func() { m.pumpClose(d, r, CloseReason{Err: readError, Text: "readerror"}) })
+		closeOnce.Do(func() {
+			reason := CloseReason{Err: readError, Text: "readerror"}
+			if isTimeout(readError) {
+				// the read deadline only expires when the device has been idle for too long
+				reason.Text = IdleTimeoutReason
+				m.measures.IdleTimeout.Inc()
+			}
+
+			m.pumpClose(d, r, reason)
+		})
 	}()
 
 	for {
-		messageType, data, readError := r.ReadMessage()
+		var (
+			messageType int
+			data        []byte
+		)
+
+		messageType, data, readError = r.ReadMessage()
 		if readError != nil {
 			d.logger.Error("read error", zap.Error(readError))
 			return
 		}
 
-		if messageType != websocket.BinaryMessage {
+		// nolint: typecheck
+		var decoder wrp.Decoder
+		switch {
+		case messageType == websocket.BinaryMessage:
+			decoder = msgpackDecoder
+		case messageType == websocket.TextMessage && m.allowTextFrames:
+			decoder = jsonDecoder
+		default:
 			d.logger.Error("skipping non-binary frame", zap.Int("messageType", messageType))
 			continue
 		}
@@ -429,6 +685,10 @@ func (m *manager) readPump(d *device, r ReadCloser, closeOnce *sync.Once) {
 
 		if !m.wrpSourceIsValid(message, d) {
 			d.logger.Error("skipping WRP message with invalid source")
+			if m.respondOnWRPSourceReject && message.IsTransactionPart() {
+				m.rejectWRPSource(d, message)
+			}
+
 			continue
 		}
 
@@ -476,6 +736,17 @@ func (m *manager) readPump(d *device, r ReadCloser, closeOnce *sync.Once) {
 	}
 }
 
+// devicePingPeriod returns the ping period for a single device connection, with any configured jitter applied
+func (m *manager) devicePingPeriod() time.Duration {
+	if m.pingJitter <= 0 {
+		return m.pingPeriod
+	}
+
+	// scale the period by a random factor in [1 - jitter, 1 + jitter)
+	factor := 1 + m.pingJitter*(2*m.random()-1)
+	return time.Duration(float64(m.pingPeriod) * factor)
+}
+
 // writePump is the goroutine which services messages addressed to the device.
 // this goroutine exits when either an explicit shutdown is requested or any
 // error occurs on the connection.
@@ -489,7 +760,7 @@ func (m *manager) writePump(d *device, w WriteCloser, pinger func() error, close
 		encoder    = wrp.NewEncoder(nil, wrp.Msgpack)
 		writeError error
 
-		pingTicker = time.NewTicker(m.pingPeriod)
+		pingTicker = time.NewTicker(m.devicePingPeriod())
 	)
 
 	// cleanup: we not only ensure that the device and connection are closed but also
@@ -530,6 +801,8 @@ func (m *manager) writePump(d *device, w WriteCloser, pinger func() error, close
 					Error:    writeError,
 				})
 			default:
+				// the queue is now empty, so report recovery from any high-water crossing
+				d.checkQueueDepth()
 				return
 			}
 		}
@@ -546,21 +819,8 @@ func (m *manager) writePump(d *device, w WriteCloser, pinger func() error, close
 			return
 
 		case envelope = <-d.messages:
-			var frameContents []byte
-			// nolint: typecheck
-			if envelope.request.Format == wrp.Msgpack && len(envelope.request.Contents) > 0 {
-				frameContents = envelope.request.Contents
-			} else {
-				// if the request was in a format other than Msgpack, or if the caller did not pass
-				// Contents, then do the encoding here.
-				encoder.ResetBytes(&frameContents)
-				writeError = encoder.Encode(envelope.request.Message)
-				encoder.ResetBytes(&emptyBuffer)
-			}
-
-			if writeError == nil {
-				writeError = w.WriteMessage(websocket.BinaryMessage, frameContents)
-			}
+			d.checkQueueDepth()
+			writeError = m.writeFrame(w, encoder, envelope.request)
 
 			event := Event{
 				Device:   d,
@@ -586,6 +846,38 @@ func (m *manager) writePump(d *device, w WriteCloser, pinger func() error, close
 	}
 }
 
+// writeFrame writes a request to a device as a single binary frame.  Requests with Msgpack
+// Contents are written as is.  Otherwise, the request's Message is encoded here, using a pooled
+// buffer when PoolFrameBuffers is set.
+// nolint: typecheck
+func (m *manager) writeFrame(w Writer, encoder wrp.Encoder, request *Request) error {
+	// nolint: typecheck
+	if request.Format == wrp.Msgpack && len(request.Contents) > 0 {
+		return w.WriteMessage(websocket.BinaryMessage, request.Contents)
+	}
+
+	var frameContents *[]byte
+	if m.framePool != nil {
+		frameContents = m.framePool.Get().(*[]byte)
+		defer func() {
+			// websocket writes are synchronous, so the frame is no longer referenced at this point
+			*frameContents = (*frameContents)[:0]
+			m.framePool.Put(frameContents)
+		}()
+	} else {
+		frameContents = new([]byte)
+	}
+
+	encoder.ResetBytes(frameContents)
+	err := encoder.Encode(request.Message)
+	encoder.ResetBytes(&emptyBuffer)
+	if err != nil {
+		return err
+	}
+
+	return w.WriteMessage(websocket.BinaryMessage, *frameContents)
+}
+
 func (m *manager) Disconnect(id ID, reason CloseReason) bool {
 	_, ok := m.devices.remove(id, reason)
 	return ok
@@ -619,8 +911,22 @@ func (m *manager) Get(id ID) (Interface, bool) {
 	return m.devices.get(id)
 }
 
+func (m *manager) GetMany(ids []ID) map[ID]Interface {
+	found := m.devices.getMany(ids)
+	devices := make(map[ID]Interface, len(found))
+	for id, d := range found {
+		devices[id] = d
+	}
+
+	return devices
+}
+
 func (m *manager) VisitAll(visitor func(Interface) bool) int {
-	return m.devices.visit(func(d *device) bool {
+	return m.VisitAllCtx(context.Background(), visitor)
+}
+
+func (m *manager) VisitAllCtx(ctx context.Context, visitor func(Interface) bool) int {
+	return m.devices.visitCtx(ctx, func(d *device) bool {
 		return visitor(d)
 	})
 }
@@ -635,6 +941,16 @@ func (m *manager) Route(request *Request) (*Response, error) {
 	}
 }
 
+func (m *manager) UpdateMetadata(id ID, update func(*Metadata)) bool {
+	return m.devices.update(id, func(d *device) {
+		update(d.metadata)
+
+		// the convey metric is labeled by partner and trust, so it has to track the new metadata
+		d.conveyClosure()
+		d.conveyClosure = m.updateConveyHWMetric(d)
+	})
+}
+
 func (m *manager) MaxDevices() int {
 	return m.devices.limit
 }
@@ -644,5 +960,5 @@ func (m *manager) isDeviceDuplicated(d *device) bool {
 	if !ok {
 		return false
 	}
-	return existing.state != d.state
+	return atomic.LoadInt32(&existing.state) != atomic.LoadInt32(&d.state)
 }
@@ -1,20 +1,29 @@
 package device
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
+	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 
 	"github.com/xmidt-org/webpa-common/v2/convey"
+	"github.com/xmidt-org/webpa-common/v2/convey/conveymetric"
 	"github.com/xmidt-org/webpa-common/v2/xmetrics"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest"
 
 	"github.com/justinas/alice"
 	"github.com/stretchr/testify/assert"
@@ -98,7 +107,12 @@ func testManagerConnectFilterDeny(t *testing.T) {
 
 	device, err := manager.Connect(response, request, nil)
 	assert.Nil(device)
-	assert.Equal(err, ErrorDeviceFilteredOut)
+	assert.ErrorIs(err, ErrorDeviceFilteredOut)
+
+	var retryErr *RetryError
+	if assert.ErrorAs(err, &retryErr) {
+		assert.Equal(DefaultRejectRetryAfter, retryErr.RetryAfter)
+	}
 
 }
 
@@ -285,6 +299,46 @@ func testManagerDisconnectIf(t *testing.T) {
 	}
 }
 
+func testManagerDisconnectIfReasons(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		manager = NewManager(&Options{
+			Logger: zap.NewNop(),
+		}).(*manager)
+
+		reasons = map[ID]CloseReason{
+			testDeviceIDs[0]: {Text: "first-reason"},
+			testDeviceIDs[1]: {Text: "second-reason"},
+		}
+
+		devices = make(map[ID]*device, len(testDeviceIDs))
+	)
+
+	for _, id := range testDeviceIDs {
+		d := newDevice(deviceOptions{ID: id, Logger: zap.NewNop()})
+		require.NoError(manager.devices.add(d))
+		devices[id] = d
+	}
+
+	assert.Equal(
+		len(reasons),
+		manager.DisconnectIf(func(candidate ID) (CloseReason, bool) {
+			reason, ok := reasons[candidate]
+			return reason, ok
+		}),
+	)
+
+	for id, d := range devices {
+		if expected, ok := reasons[id]; ok {
+			assert.True(d.Closed())
+			assert.Equal(expected, d.CloseReason())
+		} else {
+			assert.False(d.Closed())
+		}
+	}
+}
+
 func testManagerRouteBadDestination(t *testing.T) {
 	var (
 		assert  = assert.New(t)
@@ -386,168 +440,1348 @@ func testManagerConnectIncludesConvey(t *testing.T) {
 	assert.Equal("WebPA-1.6", convey["webpa-protocol"])
 }
 
-func TestManager(t *testing.T) {
-	t.Run("Connect", func(t *testing.T) {
-		t.Run("MissingDeviceContext", testManagerConnectMissingDeviceContext)
-		t.Run("FilterOutDevice", testManagerConnectFilterDeny)
-		t.Run("UpgradeError", testManagerConnectUpgradeError)
-		t.Run("Visit", testManagerConnectVisit)
-		t.Run("IncludesConvey", testManagerConnectIncludesConvey)
-	})
+func testManagerConnectCompression(t *testing.T, compressionLevel *int) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan []byte, 1)
 
-	t.Run("Route", func(t *testing.T) {
-		t.Run("BadDestination", testManagerRouteBadDestination)
-		t.Run("DeviceNotFound", testManagerRouteDeviceNotFound)
-	})
+		options = &Options{
+			Logger:           zap.NewNop(),
+			Compression:      true,
+			CompressionLevel: compressionLevel,
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == MessageReceived {
+						// nolint: typecheck
+						received <- event.Message.(*wrp.Message).Payload
+					}
+				},
+			},
+		}
 
-	t.Run("Disconnect", testManagerDisconnect)
-	t.Run("DisconnectIf", testManagerDisconnectIf)
+		m, server, connectURL = startWebsocketServer(options)
+		dialer                = NewDialer(DialerOptions{
+			WSDialer: &websocket.Dialer{EnableCompression: true},
+		})
+
+		// nolint: typecheck
+		message = &wrp.Message{
+			// nolint: typecheck
+			Type:        wrp.SimpleEventMessageType,
+			Source:      string(testDeviceIDs[0]) + "/service",
+			Destination: "event:test",
+			Payload:     bytes.Repeat([]byte("compressible payload "), 10000),
+		}
+
+		frame []byte
+	)
+
+	defer server.Close()
+
+	// nolint: typecheck
+	require.NoError(wrp.NewEncoderBytes(&frame, wrp.Msgpack).Encode(message))
+
+	deviceConnection, response, err := dialer.DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer deviceConnection.Close()
+
+	assert.Contains(response.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+	require.NoError(deviceConnection.WriteMessage(websocket.BinaryMessage, frame))
+
+	select {
+	case payload := <-received:
+		assert.Equal(message.Payload, payload)
+	case <-time.After(10 * time.Second):
+		assert.Fail("No message was received within the timeout")
+	}
+
+	// the server compresses outbound frames at the configured level, and the device must inflate them
+	// nolint: typecheck
+	outbound := &wrp.Message{
+		// nolint: typecheck
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:talaria/service",
+		Destination: string(testDeviceIDs[0]) + "/service",
+		Payload:     bytes.Repeat([]byte("outbound payload "), 10000),
+	}
+
+	_, err = m.Route(&Request{Message: outbound})
+	require.NoError(err)
+
+	require.NoError(deviceConnection.SetReadDeadline(time.Now().Add(10 * time.Second)))
+	_, frame, err = deviceConnection.ReadMessage()
+	require.NoError(err)
+
+	// nolint: typecheck
+	actual := new(wrp.Message)
+	// nolint: typecheck
+	require.NoError(wrp.NewDecoderBytes(frame, wrp.Msgpack).Decode(actual))
+	assert.Equal(outbound.Payload, actual.Payload)
 }
 
-func TestGaugeCardinality(t *testing.T) {
+func testManagerConnectPingPayload(t *testing.T) {
 	var (
-		assert = assert.New(t)
-		r, err = xmetrics.NewRegistry(nil, Metrics)
-		m      = NewManager(&Options{
-			MetricsProvider: r,
-		})
+		assert  = assert.New(t)
+		require = require.New(t)
+		pings   = make(chan string, 1)
+
+		options = &Options{
+			Logger:      zap.NewNop(),
+			PingPeriod:  50 * time.Millisecond,
+			PingPayload: []byte("custom ping"),
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
 	)
-	assert.NoError(err)
 
-	assert.NotPanics(func() {
-		dec, err := m.(*manager).conveyHWMetric.Update(convey.C{"hw-model": "cardinality", "fw-name": "firmware-number", "model": "f"}, "partnerid", "comcast", "trust", "0")
-		assert.NoError(err)
-		dec()
-	})
+	defer server.Close()
 
-	assert.Panics(func() {
-		m.(*manager).measures.Models.With("neat", "bad").Add(-1)
+	header := http.Header{DeviceNameHeader: []string{string(testDeviceIDs[0])}}
+	deviceConnection, _, err := websocket.DefaultDialer.Dial(connectURL, header)
+	require.NoError(err)
+	defer deviceConnection.Close()
+
+	deviceConnection.SetPingHandler(func(data string) error {
+		select {
+		case pings <- data:
+		default:
+		}
+
+		return nil
 	})
-}
 
-func TestWRPSourceIsValid(t *testing.T) {
-	assert := assert.New(t)
-	canonicalID := ID("mac:112233445566")
-	testData := []struct {
-		Name           string
-		Source         string
-		IsValid        bool
-		BaseLabelPairs map[string]string
-	}{
-		{
-			Name:           "EmptySource",
-			IsValid:        false,
-			Source:         "   	",
-			BaseLabelPairs: map[string]string{"reason": "empty"},
-		},
+	// control frames are only processed while reading
+	go func() {
+		for {
+			if _, _, err := deviceConnection.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
 
-		{
-			Name:           "ParseFailure",
-			IsValid:        false,
-			Source:         "serial>hacker/service",
-			BaseLabelPairs: map[string]string{"reason": "parse_error"},
-		},
-		{
-			Name:           "IDMismatch",
-			IsValid:        false,
-			Source:         "mac:665544332211/service/some/path",
-			BaseLabelPairs: map[string]string{"reason": "id_mismatch"},
-		},
-		{
-			Name:           "IDMatch",
-			IsValid:        true,
-			Source:         "mac:112233445566/service/some/path",
-			BaseLabelPairs: map[string]string{"reason": "id_match"},
-		},
+	select {
+	case data := <-pings:
+		assert.Equal("custom ping", data)
+	case <-time.After(10 * time.Second):
+		assert.Fail("No ping was received within the timeout")
 	}
+}
 
-	for _, record := range testData {
-		t.Run(record.Name, func(t *testing.T) {
-			expectedStrictLabels, expectedLenientLabels := createLabelMaps(!record.IsValid, record.BaseLabelPairs)
+func testManagerConnectRateLimited(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
 
-			d := new(device)
-			d.id = canonicalID
-			d.logger = zap.NewNop().With(zap.String("id", string(canonicalID)))
-			d.metadata = new(Metadata)
+		now     = time.Now()
+		options = &Options{
+			Logger:           zap.NewNop(),
+			ConnectRate:      1.0,
+			ConnectBurst:     2,
+			RejectRetryAfter: 5 * time.Second,
+			Now:              func() time.Time { return now },
+		}
 
-			// strict mode
-			counter := newTestCounter()
-			// nolint: typecheck
-			message := &wrp.Message{Source: record.Source}
-			m := &manager{enforceWRPSourceCheck: true, measures: Measures{WRPSourceCheck: counter}}
-			ok := m.wrpSourceIsValid(message, d)
-			assert.Equal(record.IsValid, ok)
-			assert.Equal(expectedStrictLabels, counter.labelPairs)
+		manager, server, connectURL = startWebsocketServer(options)
+		dialer                      = NewDialer(DialerOptions{})
+	)
 
-			// lenient mode
-			counter = newTestCounter()
-			// nolint: typecheck
-			message = &wrp.Message{Source: record.Source}
-			m = &manager{enforceWRPSourceCheck: false, measures: Measures{WRPSourceCheck: counter}}
+	defer server.Close()
 
-			ok = m.wrpSourceIsValid(message, d)
-			assert.True(ok)
-			assert.Equal(expectedLenientLabels, counter.labelPairs)
-		})
+	for _, id := range testDeviceIDs[:2] {
+		deviceConnection, _, err := dialer.DialDevice(string(id), connectURL, nil)
+		require.NoError(err)
+		defer deviceConnection.Close()
 	}
 
+	deviceConnection, response, err := dialer.DialDevice(string(testDeviceIDs[2]), connectURL, nil)
+	assert.Nil(deviceConnection)
+	assert.Error(err)
+	require.NotNil(response)
+	assert.Equal(http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal("5", response.Header.Get("Retry-After"))
+	assert.Eventually(func() bool { return manager.Len() == 2 }, 10*time.Second, 10*time.Millisecond)
 }
 
-func createLabelMaps(rejected bool, baseLabelPairs map[string]string) (strict map[string]string, lenient map[string]string) {
-	strict = make(map[string]string)
-	lenient = make(map[string]string)
-
-	for k, v := range baseLabelPairs {
-		strict[k] = v
-		lenient[k] = v
-	}
+func TestManagerGetMany(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		m       = NewManager(&Options{Logger: zap.NewNop()}).(*manager)
+	)
 
-	if rejected {
-		strict["outcome"] = "rejected"
-	} else {
-		strict["outcome"] = "accepted"
+	for _, id := range testDeviceIDs[:2] {
+		require.NoError(m.devices.add(newDevice(deviceOptions{ID: id, Logger: zap.NewNop()})))
 	}
-	lenient["outcome"] = "accepted"
 
-	return
+	devices := m.GetMany([]ID{testDeviceIDs[0], testDeviceIDs[1], testDeviceIDs[2]})
+	require.Len(devices, 2)
+	assert.Equal(testDeviceIDs[0], devices[testDeviceIDs[0]].ID())
+	assert.Equal(testDeviceIDs[1], devices[testDeviceIDs[1]].ID())
+	assert.NotContains(devices, testDeviceIDs[2])
 }
 
-type testCounter struct {
-	count      float64
-	labelPairs map[string]string
-}
+func TestManagerAddListener(t *testing.T) {
+	var (
+		assert = assert.New(t)
 
-func (c *testCounter) Add(delta float64) {
-	c.count += delta
+		staticEvents []EventType
+		addedEvents  []EventType
+
+		m = NewManager(&Options{
+			Logger: zap.NewNop(),
+			Listeners: []Listener{
+				func(e *Event) { staticEvents = append(staticEvents, e.Type) },
+			},
+		}).(*manager)
+	)
+
+	cancel := m.AddListener(func(e *Event) { addedEvents = append(addedEvents, e.Type) })
+	m.dispatch(&Event{Type: Connect})
+	assert.Equal([]EventType{Connect}, staticEvents)
+	assert.Equal([]EventType{Connect}, addedEvents)
+
+	cancel()
+	cancel() // idempotent
+	m.dispatch(&Event{Type: Disconnect})
+	assert.Equal([]EventType{Connect, Disconnect}, staticEvents)
+	assert.Equal([]EventType{Connect}, addedEvents, "a removed listener should not receive events")
+
+	// a listener may remove itself during dispatch
+	var (
+		selfRemoved int
+		removeSelf  func()
+	)
+
+	removeSelf = m.AddListener(func(*Event) {
+		selfRemoved++
+		removeSelf()
+	})
+
+	m.dispatch(&Event{Type: MessageSent})
+	m.dispatch(&Event{Type: MessageSent})
+	assert.Equal(1, selfRemoved)
+	assert.Empty(m.addedListeners)
 }
 
-func (c *testCounter) With(labelValues ...string) metrics.Counter {
-	for i := 0; i < len(labelValues)-1; i += 2 {
-		c.labelPairs[labelValues[i]] = labelValues[i+1]
+func testManagerConnectAddListener(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		events  = make(chan *Event, 10)
+
+		manager, server, connectURL = startWebsocketServer(&Options{Logger: zap.NewNop()})
+		dialer                      = NewDialer(DialerOptions{})
+	)
+
+	defer server.Close()
+	cancel := manager.AddListener(func(e *Event) {
+		if e.Type == Connect {
+			events <- e
+		}
+	})
+
+	first, _, err := dialer.DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer first.Close()
+
+	select {
+	case e := <-events:
+		assert.Equal(testDeviceIDs[0], e.Device.ID())
+	case <-time.After(10 * time.Second):
+		assert.Fail("No connect event was received within the timeout")
 	}
-	return c
+
+	cancel()
+	second, _, err := dialer.DialDevice(string(testDeviceIDs[1]), connectURL, nil)
+	require.NoError(err)
+	defer second.Close()
+
+	assert.Eventually(func() bool { return manager.Len() == 2 }, 10*time.Second, 10*time.Millisecond)
+	assert.Empty(events, "no events should be delivered after the listener is removed")
 }
 
-func newTestCounter() *testCounter {
-	return &testCounter{
-		labelPairs: make(map[string]string),
+func testManagerIdleTimeout(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		disconnected = make(chan CloseReason, 1)
+		p            = xmetricstest.NewProvider(nil, Metrics)
+		options      = &Options{
+			Logger:          zap.NewNop(),
+			MetricsProvider: p,
+			IdlePeriod:      100 * time.Millisecond,
+			PingPeriod:      time.Hour,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == Disconnect {
+						disconnected <- e.Device.CloseReason()
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+	deviceConnection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer deviceConnection.Close()
+
+	// a pong starts the idle period, after which the device sends nothing further
+	require.NoError(deviceConnection.WriteControl(websocket.PongMessage, nil, time.Now().Add(time.Second)))
+
+	select {
+	case reason := <-disconnected:
+		assert.Equal(IdleTimeoutReason, reason.Text)
+		assert.Error(reason.Err)
+	case <-time.After(10 * time.Second):
+		require.Fail("No disconnect event was received within the timeout")
 	}
+
+	p.Assert(t, IdleTimeoutCounter)(xmetricstest.Value(1.0))
 }
 
-func TestManagerIsDeviceDuplicated(t *testing.T) {
+func testManagerQueueCrossed(t *testing.T) {
 	var (
 		assert = assert.New(t)
-		tests  = []struct {
-			expected bool
-			existing *device
-			new      *device
-			m        *manager
-		}{
-			{
-				expected: false,
-				existing: nil,
-				new:      &device{id: "test"},
-				m: NewManager(&Options{
+		events = make(chan Event, 1)
+
+		m = NewManager(&Options{
+			Logger:         zap.NewNop(),
+			QueueHighWater: 5,
+			Listeners: []Listener{
+				func(e *Event) {
+					events <- *e
+				},
+			},
+		}).(*manager)
+
+		d = newDevice(deviceOptions{ID: ID("mac:112233445566"), Logger: zap.NewNop()})
+	)
+
+	assert.Equal(5, m.queueHighWater)
+	m.queueCrossed(d, QueueHighWater, 7)
+
+	e := <-events
+	assert.Equal(QueueHighWater, e.Type)
+	assert.Equal(d, e.Device)
+	assert.Equal(7, e.QueueDepth)
+}
+
+func testManagerQueueRecoveredOnDisconnect(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		lock       sync.Mutex
+		queueTypes []EventType
+
+		m = NewManager(&Options{
+			Logger:         zap.NewNop(),
+			QueueHighWater: 2,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == QueueHighWater || e.Type == QueueRecovered {
+						defer lock.Unlock()
+						lock.Lock()
+						queueTypes = append(queueTypes, e.Type)
+					}
+				},
+			},
+		}).(*manager)
+
+		d = newDevice(deviceOptions{
+			ID:             ID("mac:112233445566"),
+			QueueSize:      4,
+			QueueHighWater: m.queueHighWater,
+			QueueCrossed:   m.queueCrossed,
+			Logger:         zap.NewNop(),
+		})
+
+		w = new(mockConnectionWriter)
+	)
+
+	// nolint: typecheck
+	w.On("SetWriteDeadline", mock.Anything).Return(error(nil))
+	// nolint: typecheck
+	w.On("WriteMessage", websocket.BinaryMessage, mock.Anything).Return(errors.New("expected")).Once()
+	// nolint: typecheck
+	w.On("Close").Return(error(nil))
+
+	for i := 0; i < 3; i++ {
+		// nolint: typecheck
+		d.messages <- &envelope{request: &Request{Message: new(wrp.Message)}, complete: make(chan error, 1)}
+		d.checkQueueDepth()
+	}
+
+	// the failed write disconnects the device, and the remaining messages are drained
+	m.writePump(d, w, func() error { return nil }, new(sync.Once))
+	require.Zero(d.Pending())
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal([]EventType{QueueHighWater, QueueRecovered}, queueTypes)
+}
+
+func testManagerRoutePoolFrameBuffers(t *testing.T) {
+	const messageCount = 50
+
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		options = &Options{
+			Logger:           zap.NewNop(),
+			PoolFrameBuffers: true,
+		}
+
+		m, server, connectURL = startWebsocketServer(options)
+		deviceIDs             = testDeviceIDs[:2]
+	)
+
+	defer server.Close()
+
+	received := make(map[ID]chan string, len(deviceIDs))
+	for _, id := range deviceIDs {
+		deviceConnection, _, err := DefaultDialer().DialDevice(string(id), connectURL, nil)
+		require.NoError(err)
+		defer deviceConnection.Close()
+
+		payloads := make(chan string, messageCount)
+		received[id] = payloads
+		go func(id ID) {
+			for {
+				_, frame, err := deviceConnection.ReadMessage()
+				if err != nil {
+					return
+				}
+
+				// nolint: typecheck
+				message := new(wrp.Message)
+				// nolint: typecheck
+				if assert.NoError(wrp.NewDecoderBytes(frame, wrp.Msgpack).Decode(message)) {
+					assert.Equal(string(id)+"/service", message.Destination)
+					payloads <- string(message.Payload)
+				}
+			}
+		}(id)
+	}
+
+	require.Eventually(
+		func() bool { return m.Len() == len(deviceIDs) },
+		5*time.Second,
+		10*time.Millisecond,
+	)
+
+	// concurrent routes share the frame buffer pool across devices
+	var wg sync.WaitGroup
+	for _, id := range deviceIDs {
+		for i := 0; i < messageCount; i++ {
+			wg.Add(1)
+			go func(id ID, i int) {
+				defer wg.Done()
+				_, err := m.Route(&Request{
+					// nolint: typecheck
+					Message: &wrp.Message{
+						// nolint: typecheck
+						Type:        wrp.SimpleEventMessageType,
+						Source:      "dns:talaria/service",
+						Destination: string(id) + "/service",
+						Payload:     []byte(fmt.Sprintf("%s-%d", id, i)),
+					},
+				})
+
+				assert.NoError(err)
+			}(id, i)
+		}
+	}
+
+	wg.Wait()
+	for _, id := range deviceIDs {
+		expected := make(map[string]bool, messageCount)
+		actual := make(map[string]bool, messageCount)
+		for i := 0; i < messageCount; i++ {
+			expected[fmt.Sprintf("%s-%d", id, i)] = true
+
+			select {
+			case payload := <-received[id]:
+				actual[payload] = true
+			case <-time.After(10 * time.Second):
+				require.Fail("Not all messages were received within the timeout")
+			}
+		}
+
+		assert.Equal(expected, actual)
+	}
+}
+
+func testManagerSessionDuration(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		clockLock sync.Mutex
+		current   = time.Now()
+		now       = func() time.Time {
+			defer clockLock.Unlock()
+			clockLock.Lock()
+			return current
+		}
+
+		disconnected = make(chan struct{})
+		p            = xmetricstest.NewProvider(nil, Metrics)
+		options      = &Options{
+			Logger:          zap.NewNop(),
+			MetricsProvider: p,
+			Now:             now,
+			Listeners: []Listener{
+				func(e *Event) {
+					if e.Type == Disconnect {
+						close(disconnected)
+					}
+				},
+			},
+		}
+
+		manager, server, connectURL = startWebsocketServer(options)
+		dialer                      = NewDialer(DialerOptions{})
+	)
+
+	defer server.Close()
+	deviceConnection, _, err := dialer.DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer deviceConnection.Close()
+	require.Eventually(func() bool { return manager.Len() == 1 }, 10*time.Second, 10*time.Millisecond)
+
+	clockLock.Lock()
+	current = current.Add(10 * time.Minute)
+	clockLock.Unlock()
+
+	require.True(manager.Disconnect(testDeviceIDs[0], CloseReason{Text: "test"}))
+	select {
+	case <-disconnected:
+	case <-time.After(10 * time.Second):
+		require.Fail("No disconnect event was received within the timeout")
+	}
+
+	p.Assert(t, SessionDurationHistogram)(xmetricstest.Histogram)
+
+	// the test provider returns the existing histogram, whose single observation must fall in the (300, 900] bucket
+	histogram, ok := p.NewHistogram(SessionDurationHistogram, 9).(interface{ Quantile(float64) float64 })
+	require.True(ok)
+	median := histogram.Quantile(0.5)
+	assert.Greater(median, 300.0)
+	assert.LessOrEqual(median, 900.0)
+}
+
+func TestManagerDevicePingPeriod(t *testing.T) {
+	t.Run("NoJitter", func(t *testing.T) {
+		m := NewManager(&Options{PingPeriod: time.Minute}).(*manager)
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, time.Minute, m.devicePingPeriod())
+		}
+	})
+
+	t.Run("Bounds", func(t *testing.T) {
+		var (
+			assert = assert.New(t)
+			random float64
+			m      = NewManager(&Options{PingPeriod: time.Minute, PingJitter: 0.1}).(*manager)
+		)
+
+		m.random = func() float64 { return random }
+		assert.Equal(54*time.Second, m.devicePingPeriod())
+
+		random = 0.5
+		assert.Equal(time.Minute, m.devicePingPeriod())
+
+		random = 0.75
+		assert.Equal(63*time.Second, m.devicePingPeriod())
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		var (
+			m        = NewManager(&Options{PingPeriod: time.Minute, PingJitter: 0.2}).(*manager)
+			distinct = make(map[time.Duration]bool)
+		)
+
+		for i := 0; i < 1000; i++ {
+			period := m.devicePingPeriod()
+			assert.GreaterOrEqual(t, period, 48*time.Second)
+			assert.Less(t, period, 72*time.Second)
+			distinct[period] = true
+		}
+
+		assert.Greater(t, len(distinct), 1, "ping periods should be jittered")
+	})
+}
+
+func testManagerConnectTextFrames(t *testing.T, allowTextFrames bool) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan *Event, 2)
+
+		options = &Options{
+			Logger:          zap.NewNop(),
+			AllowTextFrames: allowTextFrames,
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == MessageReceived {
+						received <- event
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+		dialer                = NewDialer(DialerOptions{})
+
+		// nolint: typecheck
+		message = &wrp.Message{
+			// nolint: typecheck
+			Type:        wrp.SimpleEventMessageType,
+			Source:      string(testDeviceIDs[0]) + "/service",
+			Destination: "event:test",
+			ContentType: "text/plain",
+			Payload:     []byte("text frame test"),
+		}
+
+		jsonFrame, msgpackFrame []byte
+	)
+
+	defer server.Close()
+
+	// nolint: typecheck
+	require.NoError(wrp.NewEncoderBytes(&jsonFrame, wrp.JSON).Encode(message))
+	// nolint: typecheck
+	require.NoError(wrp.NewEncoderBytes(&msgpackFrame, wrp.Msgpack).Encode(message))
+
+	deviceConnection, _, err := dialer.DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer deviceConnection.Close()
+
+	require.NoError(deviceConnection.WriteMessage(websocket.TextMessage, jsonFrame))
+	require.NoError(deviceConnection.WriteMessage(websocket.BinaryMessage, msgpackFrame))
+
+	var events []*Event
+	for len(events) < 2 {
+		select {
+		case e := <-received:
+			events = append(events, e)
+		case <-time.After(2 * time.Second):
+			// when text frames are not allowed, only the binary frame is received
+			require.False(allowTextFrames, "Not all messages were received within the timeout")
+			require.Len(events, 1)
+			return
+		}
+	}
+
+	require.True(allowTextFrames, "A text frame was received when text frames are not allowed")
+	textEvent, binaryEvent := events[0], events[1]
+
+	// nolint: typecheck
+	assert.Equal(wrp.Msgpack, textEvent.Format)
+	assert.Equal(binaryEvent.Format, textEvent.Format)
+
+	// the contents of the text frame are re-encoded as Msgpack
+	// nolint: typecheck
+	decoded := new(wrp.Message)
+	// nolint: typecheck
+	require.NoError(wrp.NewDecoderBytes(textEvent.Contents, wrp.Msgpack).Decode(decoded))
+	assert.Equal(textEvent.Message, decoded)
+
+	// the messages differ only by the time each was received
+	// nolint: typecheck
+	textMessage, binaryMessage := textEvent.Message.(*wrp.Message), binaryEvent.Message.(*wrp.Message)
+	assert.NotEmpty(textMessage.Metadata[WRPTimestampMetadataKey])
+	delete(textMessage.Metadata, WRPTimestampMetadataKey)
+	delete(binaryMessage.Metadata, WRPTimestampMetadataKey)
+	assert.Equal(binaryMessage, textMessage)
+}
+
+func testManagerConnectSubprotocols(t *testing.T) {
+	options := &Options{
+		Logger:       zap.NewNop(),
+		Subprotocols: []string{"wrp-0.2", "wrp-0.1"},
+	}
+
+	_, server, connectURL := startWebsocketServer(options)
+	defer server.Close()
+
+	tests := []struct {
+		description         string
+		requested           []string
+		expectedSubprotocol string
+		expectedStatusCode  int
+	}{
+		{
+			description:         "Supported",
+			requested:           []string{"wrp-0.1"},
+			expectedSubprotocol: "wrp-0.1",
+			expectedStatusCode:  http.StatusSwitchingProtocols,
+		},
+		{
+			description:         "Preferred",
+			requested:           []string{"wrp-0.1", "wrp-0.2"},
+			expectedSubprotocol: "wrp-0.2",
+			expectedStatusCode:  http.StatusSwitchingProtocols,
+		},
+		{
+			description:        "Unsupported",
+			requested:          []string{"wrp-9.9"},
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Absent",
+			expectedStatusCode: http.StatusSwitchingProtocols,
+		},
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				dialer  = websocket.Dialer{Subprotocols: tc.requested}
+			)
+
+			header := http.Header{DeviceNameHeader: []string{string(testDeviceIDs[i])}}
+			c, response, err := dialer.Dial(connectURL, header)
+			require.NotNil(response)
+			assert.Equal(tc.expectedStatusCode, response.StatusCode)
+			if tc.expectedStatusCode != http.StatusSwitchingProtocols {
+				assert.Error(err)
+				return
+			}
+
+			require.NoError(err)
+			defer c.Close()
+			assert.Equal(tc.expectedSubprotocol, c.Subprotocol())
+		})
+	}
+}
+
+func testManagerConnectConveyCompliance(t *testing.T, report bool) {
+	options := &Options{
+		Logger:                 zap.NewNop(),
+		ReportConveyCompliance: report,
+	}
+
+	_, server, connectURL := startWebsocketServer(options)
+	defer server.Close()
+
+	tests := []struct {
+		description        string
+		convey             string
+		expectedCompliance convey.Compliance
+	}{
+		{
+			description:        "Full",
+			convey:             "eyAgDQogICAiaHctc2VyaWFsLW51bWJlciI6MTIzNDU2Nzg5LA0KICAgIndlYnBhLXByb3RvY29sIjoiV2ViUEEtMS42Ig0KfQ==",
+			expectedCompliance: convey.Full,
+		},
+		{
+			description:        "Invalid",
+			convey:             "this is not convey",
+			expectedCompliance: convey.Invalid,
+		},
+		{
+			description:        "Missing",
+			expectedCompliance: convey.Missing,
+		},
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			var (
+				assert  = assert.New(t)
+				require = require.New(t)
+				header  = make(http.Header)
+			)
+
+			if len(tc.convey) > 0 {
+				header.Set(ConveyHeader, tc.convey)
+			}
+
+			c, response, err := DefaultDialer().DialDevice(string(testDeviceIDs[i]), connectURL, header)
+			require.NoError(err)
+			require.NotNil(response)
+			defer c.Close()
+
+			if report {
+				assert.Equal(tc.expectedCompliance.String(), response.Header.Get(ConveyComplianceHeader))
+			} else {
+				assert.Empty(response.Header.Get(ConveyComplianceHeader))
+			}
+		})
+	}
+}
+
+func testManagerConnectGzipConvey(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		options = &Options{
+			Logger:                 zap.NewNop(),
+			ReportConveyCompliance: true,
+			GzipConvey:             true,
+		}
+
+		buffer bytes.Buffer
+		writer = gzip.NewWriter(&buffer)
+	)
+
+	_, err := writer.Write([]byte(`{"hw-model": "abc", "fw-name": "def"}`))
+	require.NoError(err)
+	require.NoError(writer.Close())
+
+	_, server, connectURL := startWebsocketServer(options)
+	defer server.Close()
+
+	header := http.Header{ConveyHeader: {base64.StdEncoding.EncodeToString(buffer.Bytes())}}
+	c, response, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, header)
+	require.NoError(err)
+	require.NotNil(response)
+	defer c.Close()
+
+	assert.Equal(convey.Full.String(), response.Header.Get(ConveyComplianceHeader))
+}
+
+func testManagerConnectConveyMetricPairs(t *testing.T) {
+	var (
+		conveyMetricPairs = []conveymetric.TagLabelPair{
+			{Tag: "hw-manufacturer", Label: "manufacturer"},
+		}
+
+		assert  = assert.New(t)
+		require = require.New(t)
+		options = &Options{
+			Logger:            zap.NewNop(),
+			MetricsProvider:   xmetricstest.NewProvider(nil, MetricsWithConveyPairs(conveyMetricPairs...)),
+			ConveyMetricPairs: conveyMetricPairs,
+		}
+	)
+
+	cvy, err := convey.WriteString(
+		convey.NewTranslator(nil),
+		convey.C{"hw-model": "abc", "fw-name": "def", "hw-manufacturer": "acme"},
+	)
+
+	require.NoError(err)
+
+	m, server, connectURL := startWebsocketServer(options)
+	defer server.Close()
+
+	c, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, http.Header{ConveyHeader: {cvy}})
+	require.NoError(err)
+	defer c.Close()
+
+	gauge := m.(*manager).measures.Models.With(
+		"model", "abc", "firmware", "def", "manufacturer", "acme", "partnerid", UnknownPartner, "trust", "0",
+	)
+
+	assert.Eventually(
+		func() bool {
+			return gauge.(xmetrics.Valuer).Value() == 1.0
+		},
+		5*time.Second,
+		10*time.Millisecond,
+	)
+}
+
+func testManagerConnectWRPSourceRejected(t *testing.T, respondOnReject bool) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		received = make(chan string, 1)
+
+		options = &Options{
+			Logger: zap.NewNop(),
+			WRPSourceCheck: wrpSourceCheckConfig{
+				Type:            CheckTypeEnforce,
+				RespondOnReject: respondOnReject,
+			},
+			Listeners: []Listener{
+				func(event *Event) {
+					if event.Type == MessageReceived {
+						// nolint: typecheck
+						received <- event.Message.(*wrp.Message).Destination
+					}
+				},
+			},
+		}
+
+		_, server, connectURL = startWebsocketServer(options)
+
+		// nolint: typecheck
+		spoofed = &wrp.Message{
+			// nolint: typecheck
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "mac:665544332211/service",
+			Destination:     "dns:talaria/service",
+			TransactionUUID: "spoofed-transaction",
+		}
+
+		// nolint: typecheck
+		valid = &wrp.Message{
+			// nolint: typecheck
+			Type:        wrp.SimpleEventMessageType,
+			Source:      string(testDeviceIDs[0]) + "/service",
+			Destination: "event:test",
+		}
+
+		spoofedFrame, validFrame []byte
+	)
+
+	defer server.Close()
+
+	// nolint: typecheck
+	require.NoError(wrp.NewEncoderBytes(&spoofedFrame, wrp.Msgpack).Encode(spoofed))
+	// nolint: typecheck
+	require.NoError(wrp.NewEncoderBytes(&validFrame, wrp.Msgpack).Encode(valid))
+
+	deviceConnection, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer deviceConnection.Close()
+
+	require.NoError(deviceConnection.WriteMessage(websocket.BinaryMessage, spoofedFrame))
+	require.NoError(deviceConnection.WriteMessage(websocket.BinaryMessage, validFrame))
+
+	// the spoofed message is never dispatched, but the device stays connected
+	select {
+	case destination := <-received:
+		assert.Equal(valid.Destination, destination)
+	case <-time.After(10 * time.Second):
+		require.Fail("No message was received within the timeout")
+	}
+
+	if !respondOnReject {
+		require.NoError(deviceConnection.SetReadDeadline(time.Now().Add(200 * time.Millisecond)))
+		_, _, err = deviceConnection.ReadMessage()
+		assert.Error(err, "no response should be sent to the device")
+		return
+	}
+
+	require.NoError(deviceConnection.SetReadDeadline(time.Now().Add(10 * time.Second)))
+	messageType, frame, err := deviceConnection.ReadMessage()
+	require.NoError(err)
+	assert.Equal(websocket.BinaryMessage, messageType)
+
+	// nolint: typecheck
+	response := new(wrp.Message)
+	// nolint: typecheck
+	require.NoError(wrp.NewDecoderBytes(frame, wrp.Msgpack).Decode(response))
+	assert.Equal(spoofed.Type, response.Type)
+	assert.Equal(spoofed.TransactionUUID, response.TransactionUUID)
+	assert.Equal(spoofed.Destination, response.Source)
+	assert.Equal(string(testDeviceIDs[0]), response.Destination)
+	require.NotNil(response.Status)
+	assert.Equal(int64(http.StatusForbidden), *response.Status)
+	assert.Equal(ErrorInvalidWRPSource.Error(), string(response.Payload))
+}
+
+func testManagerConnectDeviceLimit(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		options = &Options{
+			Logger:           zap.NewNop(),
+			MaxDevices:       1,
+			RejectRetryAfter: 5 * time.Second,
+		}
+
+		m, server, connectURL = startWebsocketServer(options)
+	)
+
+	defer server.Close()
+
+	first, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	defer first.Close()
+
+	require.Eventually(
+		func() bool { return m.Len() == 1 },
+		5*time.Second,
+		10*time.Millisecond,
+	)
+
+	c, response, err := DefaultDialer().DialDevice(string(testDeviceIDs[1]), connectURL, nil)
+	assert.Error(err)
+	assert.Nil(c)
+	require.NotNil(response)
+	assert.Equal(http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal("5", response.Header.Get("Retry-After"))
+
+	// a device replacing its own connection doesn't count against the limit
+	duplicate, _, err := DefaultDialer().DialDevice(string(testDeviceIDs[0]), connectURL, nil)
+	require.NoError(err)
+	duplicate.Close()
+}
+
+func TestManager(t *testing.T) {
+	t.Run("Connect", func(t *testing.T) {
+		t.Run("MissingDeviceContext", testManagerConnectMissingDeviceContext)
+		t.Run("FilterOutDevice", testManagerConnectFilterDeny)
+		t.Run("UpgradeError", testManagerConnectUpgradeError)
+		t.Run("Visit", testManagerConnectVisit)
+		t.Run("IncludesConvey", testManagerConnectIncludesConvey)
+		t.Run("Compression", func(t *testing.T) {
+			noCompression, bestCompression := flate.NoCompression, flate.BestCompression
+			t.Run("Default", func(t *testing.T) { testManagerConnectCompression(t, nil) })
+			t.Run("NoCompression", func(t *testing.T) { testManagerConnectCompression(t, &noCompression) })
+			t.Run("BestCompression", func(t *testing.T) { testManagerConnectCompression(t, &bestCompression) })
+		})
+		t.Run("PingPayload", testManagerConnectPingPayload)
+		t.Run("RateLimited", testManagerConnectRateLimited)
+		t.Run("AddListener", testManagerConnectAddListener)
+		t.Run("Subprotocols", testManagerConnectSubprotocols)
+		t.Run("ConveyCompliance", func(t *testing.T) {
+			t.Run("Reported", func(t *testing.T) { testManagerConnectConveyCompliance(t, true) })
+			t.Run("NotReported", func(t *testing.T) { testManagerConnectConveyCompliance(t, false) })
+		})
+
+		t.Run("GzipConvey", testManagerConnectGzipConvey)
+		t.Run("ConveyMetricPairs", testManagerConnectConveyMetricPairs)
+		t.Run("DeviceLimit", testManagerConnectDeviceLimit)
+		t.Run("WRPSourceRejected", func(t *testing.T) {
+			t.Run("Respond", func(t *testing.T) { testManagerConnectWRPSourceRejected(t, true) })
+			t.Run("Silent", func(t *testing.T) { testManagerConnectWRPSourceRejected(t, false) })
+		})
+		t.Run("TextFrames", func(t *testing.T) {
+			t.Run("Allowed", func(t *testing.T) { testManagerConnectTextFrames(t, true) })
+			t.Run("NotAllowed", func(t *testing.T) { testManagerConnectTextFrames(t, false) })
+		})
+	})
+
+	t.Run("Route", func(t *testing.T) {
+		t.Run("PoolFrameBuffers", testManagerRoutePoolFrameBuffers)
+		t.Run("BadDestination", testManagerRouteBadDestination)
+		t.Run("DeviceNotFound", testManagerRouteDeviceNotFound)
+	})
+
+	t.Run("Disconnect", testManagerDisconnect)
+	t.Run("SessionDuration", testManagerSessionDuration)
+	t.Run("IdleTimeout", testManagerIdleTimeout)
+	t.Run("QueueCrossed", testManagerQueueCrossed)
+	t.Run("QueueRecoveredOnDisconnect", testManagerQueueRecoveredOnDisconnect)
+	t.Run("DisconnectIf", testManagerDisconnectIf)
+	t.Run("DisconnectIfReasons", testManagerDisconnectIfReasons)
+}
+
+func TestGaugeCardinality(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		r, err = xmetrics.NewRegistry(nil, Metrics)
+		m      = NewManager(&Options{
+			MetricsProvider: r,
+		})
+	)
+	assert.NoError(err)
+
+	assert.NotPanics(func() {
+		dec, err := m.(*manager).conveyHWMetric.Update(convey.C{"hw-model": "cardinality", "fw-name": "firmware-number", "model": "f"}, "partnerid", "comcast", "trust", "0")
+		assert.NoError(err)
+		dec()
+	})
+
+	assert.Panics(func() {
+		m.(*manager).measures.Models.With("neat", "bad").Add(-1)
+	})
+}
+
+func TestGaugeCardinalityConveyMetricPairs(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		conveyMetricPairs = []conveymetric.TagLabelPair{
+			{Tag: "hw-manufacturer", Label: "manufacturer"},
+			{Tag: "hw-model", Label: "model"},
+		}
+	)
+
+	r, err := xmetrics.NewRegistry(nil, MetricsWithConveyPairs(conveyMetricPairs...))
+	require.NoError(err)
+
+	m := NewManager(&Options{
+		MetricsProvider:   r,
+		ConveyMetricPairs: conveyMetricPairs[:1],
+	})
+
+	assert.NotPanics(func() {
+		dec, err := m.(*manager).conveyHWMetric.Update(convey.C{"hw-model": "cardinality", "fw-name": "firmware-number", "hw-manufacturer": "acme"}, "partnerid", "comcast", "trust", "0")
+		assert.NoError(err)
+
+		families, err := r.Gather()
+		require.NoError(err)
+
+		var manufacturers []string
+		for _, family := range families {
+			if !strings.HasSuffix(family.GetName(), ModelGauge) {
+				continue
+			}
+
+			for _, m := range family.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "manufacturer" && m.GetGauge().GetValue() == 1.0 {
+						manufacturers = append(manufacturers, label.GetValue())
+					}
+				}
+			}
+		}
+
+		assert.Equal([]string{"acme"}, manufacturers)
+		dec()
+	})
+
+	r, err = xmetrics.NewRegistry(nil, Metrics)
+	require.NoError(err)
+
+	m = NewManager(&Options{
+		MetricsProvider:   r,
+		ConveyMetricPairs: conveyMetricPairs[:1],
+	})
+
+	assert.Panics(func() {
+		m.(*manager).conveyHWMetric.Update(convey.C{"hw-model": "cardinality", "fw-name": "firmware-number", "hw-manufacturer": "acme"}, "partnerid", "comcast", "trust", "0")
+	}, "the default metrics do not have a label for extra convey pairs")
+}
+
+func TestManagerUpdateMetadata(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		p = xmetricstest.NewProvider(nil, Metrics)
+		m = NewManager(&Options{
+			Logger:          zap.NewNop(),
+			MetricsProvider: p,
+		}).(*manager)
+
+		metadata = new(Metadata)
+		d        = newDevice(deviceOptions{
+			ID:       ID("mac:112233445566"),
+			C:        convey.C{"hw-model": "model", "fw-name": "firmware"},
+			Logger:   zap.NewNop(),
+			Metadata: metadata,
+		})
+	)
+
+	metadata.SetClaims(map[string]interface{}{
+		PartnerIDClaimKey: "old-partner",
+		TrustClaimKey:     0,
+	})
+
+	d.conveyClosure = m.updateConveyHWMetric(d)
+	require.NoError(m.devices.add(d))
+	p.Assert(t, ModelGauge, "model", "model", "firmware", "firmware", "partnerid", "old-partner", "trust", "0")(xmetricstest.Value(1.0))
+
+	assert.False(m.UpdateMetadata(ID("mac:665544332211"), func(*Metadata) {
+		assert.Fail("The update function should not have been called for a missing device")
+	}))
+
+	assert.True(m.UpdateMetadata(d.ID(), func(actual *Metadata) {
+		assert.True(actual == metadata)
+		claims := actual.ClaimsCopy()
+		claims[PartnerIDClaimKey] = "new-partner"
+		claims[TrustClaimKey] = 1000
+		actual.SetClaims(claims)
+	}))
+
+	assert.Equal("new-partner", d.Metadata().PartnerIDClaim())
+	assert.Equal(1000, d.Metadata().TrustClaim())
+	p.Assert(t, ModelGauge, "model", "model", "firmware", "firmware", "partnerid", "old-partner", "trust", "0")(xmetricstest.Value(0.0))
+	p.Assert(t, ModelGauge, "model", "model", "firmware", "firmware", "partnerid", "new-partner", "trust", "1000")(xmetricstest.Value(1.0))
+
+	// the replaced closure must reverse the updated metric
+	d.conveyClosure()
+	p.Assert(t, ModelGauge, "model", "model", "firmware", "firmware", "partnerid", "new-partner", "trust", "1000")(xmetricstest.Value(0.0))
+}
+
+func TestWRPSourceIsValid(t *testing.T) {
+	assert := assert.New(t)
+	canonicalID := ID("mac:112233445566")
+	testData := []struct {
+		Name           string
+		Source         string
+		IsValid        bool
+		BaseLabelPairs map[string]string
+	}{
+		{
+			Name:           "EmptySource",
+			IsValid:        false,
+			Source:         "   	",
+			BaseLabelPairs: map[string]string{"reason": "empty"},
+		},
+
+		{
+			Name:           "ParseFailure",
+			IsValid:        false,
+			Source:         "serial>hacker/service",
+			BaseLabelPairs: map[string]string{"reason": "parse_error"},
+		},
+		{
+			Name:           "IDMismatch",
+			IsValid:        false,
+			Source:         "mac:665544332211/service/some/path",
+			BaseLabelPairs: map[string]string{"reason": "id_mismatch"},
+		},
+		{
+			Name:           "IDMatch",
+			IsValid:        true,
+			Source:         "mac:112233445566/service/some/path",
+			BaseLabelPairs: map[string]string{"reason": "id_match"},
+		},
+	}
+
+	for _, record := range testData {
+		t.Run(record.Name, func(t *testing.T) {
+			expectedStrictLabels, expectedLenientLabels := createLabelMaps(!record.IsValid, record.BaseLabelPairs)
+
+			d := new(device)
+			d.id = canonicalID
+			d.logger = zap.NewNop().With(zap.String("id", string(canonicalID)))
+			d.metadata = new(Metadata)
+
+			// strict mode
+			counter := newTestCounter()
+			// nolint: typecheck
+			message := &wrp.Message{Source: record.Source}
+			m := &manager{enforceWRPSourceCheck: true, measures: Measures{WRPSourceCheck: counter}}
+			ok := m.wrpSourceIsValid(message, d)
+			assert.Equal(record.IsValid, ok)
+			assert.Equal(expectedStrictLabels, counter.labelPairs)
+
+			// lenient mode
+			counter = newTestCounter()
+			// nolint: typecheck
+			message = &wrp.Message{Source: record.Source}
+			m = &manager{enforceWRPSourceCheck: false, measures: Measures{WRPSourceCheck: counter}}
+
+			ok = m.wrpSourceIsValid(message, d)
+			assert.True(ok)
+			assert.Equal(expectedLenientLabels, counter.labelPairs)
+		})
+	}
+
+}
+
+func TestWRPSourceIsValidRejectEmpty(t *testing.T) {
+	testData := []struct {
+		enforce, rejectEmpty      bool
+		emptyValid, mismatchValid bool
+	}{
+		{enforce: false, rejectEmpty: false, emptyValid: true, mismatchValid: true},
+		{enforce: false, rejectEmpty: true, emptyValid: false, mismatchValid: true},
+		{enforce: true, rejectEmpty: false, emptyValid: false, mismatchValid: false},
+		{enforce: true, rejectEmpty: true, emptyValid: false, mismatchValid: false},
+	}
+
+	for _, record := range testData {
+		t.Run(fmt.Sprintf("enforce=%t,rejectEmpty=%t", record.enforce, record.rejectEmpty), func(t *testing.T) {
+			checkType := CheckTypeMonitor
+			if record.enforce {
+				checkType = CheckTypeEnforce
+			}
+
+			var (
+				assert = assert.New(t)
+				m      = NewManager(&Options{
+					Logger:               zap.NewNop(),
+					WRPSourceCheck:       wrpSourceCheckConfig{Type: checkType},
+					RejectEmptyWRPSource: record.rejectEmpty,
+				}).(*manager)
+
+				d = newDevice(deviceOptions{ID: ID("mac:112233445566"), Logger: zap.NewNop(), Metadata: new(Metadata)})
+			)
+
+			// nolint: typecheck
+			assert.Equal(record.emptyValid, m.wrpSourceIsValid(&wrp.Message{}, d))
+			// nolint: typecheck
+			assert.Equal(record.mismatchValid, m.wrpSourceIsValid(&wrp.Message{Source: "mac:665544332211/service"}, d))
+			// nolint: typecheck
+			assert.True(m.wrpSourceIsValid(&wrp.Message{Source: "mac:112233445566/service"}, d))
+		})
+	}
+}
+
+func TestWRPSourceIsValidIP(t *testing.T) {
+	assert := assert.New(t)
+
+	d := new(device)
+	d.id = ID("ip:2001:db8::1")
+	d.logger = zap.NewNop()
+	d.metadata = new(Metadata)
+
+	m := &manager{enforceWRPSourceCheck: true, measures: Measures{WRPSourceCheck: newTestCounter()}}
+
+	// nolint: typecheck
+	assert.True(m.wrpSourceIsValid(&wrp.Message{Source: "ip:2001:DB8:0::1/service"}, d))
+	// nolint: typecheck
+	assert.False(m.wrpSourceIsValid(&wrp.Message{Source: "ip:2001:db8::2/service"}, d))
+	// nolint: typecheck
+	assert.False(m.wrpSourceIsValid(&wrp.Message{Source: "ip:2001:db8::zz/service"}, d))
+}
+
+func createLabelMaps(rejected bool, baseLabelPairs map[string]string) (strict map[string]string, lenient map[string]string) {
+	strict = make(map[string]string)
+	lenient = make(map[string]string)
+
+	for k, v := range baseLabelPairs {
+		strict[k] = v
+		lenient[k] = v
+	}
+
+	if rejected {
+		strict["outcome"] = "rejected"
+	} else {
+		strict["outcome"] = "accepted"
+	}
+	lenient["outcome"] = "accepted"
+
+	return
+}
+
+type testCounter struct {
+	count      float64
+	labelPairs map[string]string
+}
+
+func (c *testCounter) Add(delta float64) {
+	c.count += delta
+}
+
+func (c *testCounter) With(labelValues ...string) metrics.Counter {
+	for i := 0; i < len(labelValues)-1; i += 2 {
+		c.labelPairs[labelValues[i]] = labelValues[i+1]
+	}
+	return c
+}
+
+func newTestCounter() *testCounter {
+	return &testCounter{
+		labelPairs: make(map[string]string),
+	}
+}
+
+func TestManagerIsDeviceDuplicated(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		tests  = []struct {
+			expected bool
+			existing *device
+			new      *device
+			m        *manager
+		}{
+			{
+				expected: false,
+				existing: nil,
+				new:      &device{id: "test"},
+				m: NewManager(&Options{
 					MaxDevices: 0,
 				}).(*manager),
 			},
@@ -580,3 +1814,45 @@ func TestManagerIsDeviceDuplicated(t *testing.T) {
 		assert.Equal(test.expected, test.m.isDeviceDuplicated(test.new))
 	}
 }
+
+// discardWriter is a Writer that discards all frames
+type discardWriter struct{}
+
+func (discardWriter) WriteMessage(int, []byte) error                        { return nil }
+func (discardWriter) WritePreparedMessage(*websocket.PreparedMessage) error { return nil }
+func (discardWriter) SetWriteDeadline(time.Time) error                      { return nil }
+
+func benchmarkManagerWriteFrame(b *testing.B, poolFrameBuffers bool) {
+	var (
+		m = NewManager(&Options{
+			Logger:           zap.NewNop(),
+			PoolFrameBuffers: poolFrameBuffers,
+		}).(*manager)
+
+		// nolint: typecheck
+		encoder = wrp.NewEncoder(nil, wrp.Msgpack)
+		request = &Request{
+			// nolint: typecheck
+			Message: &wrp.Message{
+				// nolint: typecheck
+				Type:        wrp.SimpleEventMessageType,
+				Source:      "dns:talaria/service",
+				Destination: string(testDeviceIDs[0]) + "/service",
+				Payload:     bytes.Repeat([]byte("payload "), 512),
+			},
+		}
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.writeFrame(discardWriter{}, encoder, request); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManagerWriteFrame(b *testing.B) {
+	b.Run("Unpooled", func(b *testing.B) { benchmarkManagerWriteFrame(b, false) })
+	b.Run("Pooled", func(b *testing.B) { benchmarkManagerWriteFrame(b, true) })
+}
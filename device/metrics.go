@@ -3,6 +3,7 @@ package device
 import (
 	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/provider"
+	"github.com/xmidt-org/webpa-common/v2/convey/conveymetric"
 
 	// nolint:staticcheck
 	"github.com/xmidt-org/webpa-common/v2/xmetrics"
@@ -17,12 +18,45 @@ const (
 	ConnectCounter            = "connect_count"
 	DisconnectCounter         = "disconnect_count"
 	DeviceLimitReachedCounter = "device_limit_reached_count"
+	IdleTimeoutCounter        = "idle_timeout_count"
 	ModelGauge                = "hardware_model"
 	WRPSourceCheck            = "wrp_source_check"
+	SessionDurationHistogram  = "session_duration_seconds"
 )
 
+// modelLabelNames are the default label names of the ModelGauge
+var modelLabelNames = []string{"model", "partnerid", "firmware", "trust"}
+
 // Metrics is the device module function that adds default device metrics
 func Metrics() []xmetrics.Metric {
+	return MetricsWithConveyPairs()()
+}
+
+// MetricsWithConveyPairs produces a device module function whose ModelGauge also has a label for each
+// of the given pairs.  This module must be used when Options.ConveyMetricPairs is set, using the same pairs.
+func MetricsWithConveyPairs(pairs ...conveymetric.TagLabelPair) xmetrics.Module {
+	labelNames := append([]string{}, modelLabelNames...)
+	for _, pair := range pairs {
+		found := false
+		for _, labelName := range labelNames {
+			if labelName == pair.Label {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			labelNames = append(labelNames, pair.Label)
+		}
+	}
+
+	return func() []xmetrics.Metric {
+		return deviceMetrics(labelNames)
+	}
+}
+
+// deviceMetrics produces the device metrics, using the given label names for the ModelGauge
+func deviceMetrics(modelGaugeLabelNames []string) []xmetrics.Metric {
 	return []xmetrics.Metric{
 		{
 			Name: DeviceCounter,
@@ -56,16 +90,27 @@ func Metrics() []xmetrics.Metric {
 			Name: DeviceLimitReachedCounter,
 			Type: "counter",
 		},
+		{
+			Name: IdleTimeoutCounter,
+			Type: "counter",
+			Help: "The number of devices disconnected because they were idle for longer than the idle period",
+		},
 		{
 			Name:       ModelGauge,
 			Type:       "gauge",
-			LabelNames: []string{"model", "partnerid", "firmware", "trust"},
+			LabelNames: modelGaugeLabelNames,
 		},
 		{
 			Name:       WRPSourceCheck,
 			Type:       "counter",
 			LabelNames: []string{"outcome", "reason"},
 		},
+		{
+			Name:    SessionDurationHistogram,
+			Type:    "histogram",
+			Help:    "A histogram of how long device sessions last, from connection to disconnection",
+			Buckets: []float64{60, 300, 900, 3600, 14400, 43200, 86400, 259200, 604800},
+		},
 	}
 }
 
@@ -79,8 +124,10 @@ type Measures struct {
 	Pong            xmetrics.Incrementer
 	Connect         xmetrics.Incrementer
 	Disconnect      xmetrics.Adder
+	IdleTimeout     xmetrics.Incrementer
 	Models          metrics.Gauge
 	WRPSourceCheck  metrics.Counter
+	SessionDuration metrics.Histogram
 }
 
 // NewMeasures constructs a Measures given a go-kit metrics Provider
@@ -94,7 +141,9 @@ func NewMeasures(p provider.Provider) Measures {
 		Duplicates:      xmetrics.NewIncrementer(p.NewCounter(DuplicatesCounter)),
 		Connect:         xmetrics.NewIncrementer(p.NewCounter(ConnectCounter)),
 		Disconnect:      p.NewCounter(DisconnectCounter),
+		IdleTimeout:     xmetrics.NewIncrementer(p.NewCounter(IdleTimeoutCounter)),
 		Models:          p.NewGauge(ModelGauge),
 		WRPSourceCheck:  p.NewCounter(WRPSourceCheck),
+		SessionDuration: p.NewHistogram(SessionDurationHistogram, 9),
 	}
 }
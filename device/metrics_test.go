@@ -26,7 +26,7 @@ func TestMetrics(t *testing.T) {
 		gauge.Add(-1.0)
 	}
 
-	for _, counterName := range []string{RequestResponseCounter, PingCounter, PongCounter, ConnectCounter, DisconnectCounter} {
+	for _, counterName := range []string{RequestResponseCounter, PingCounter, PongCounter, ConnectCounter, DisconnectCounter, IdleTimeoutCounter} {
 		counter := r.NewCounter(counterName)
 		counter.Add(1.0)
 	}
@@ -44,4 +44,6 @@ func TestNewMeasures(t *testing.T) {
 	assert.NotNil(m.Pong)
 	assert.NotNil(m.Connect)
 	assert.NotNil(m.Disconnect)
+	assert.NotNil(m.IdleTimeout)
+	assert.NotNil(m.SessionDuration)
 }
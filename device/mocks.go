@@ -1,6 +1,7 @@
 package device
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/stretchr/testify/mock"
@@ -58,11 +59,26 @@ func (m *MockRegistry) Get(id ID) (Interface, bool) {
 	return first, arguments.Bool(1)
 }
 
+func (m *MockRegistry) GetMany(ids []ID) map[ID]Interface {
+	// nolint: typecheck
+	first, _ := m.Called(ids).Get(0).(map[ID]Interface)
+	if first == nil {
+		first = make(map[ID]Interface)
+	}
+
+	return first
+}
+
 func (m *MockRegistry) VisitAll(f func(Interface) bool) int {
 	// nolint: typecheck
 	return m.Called(f).Int(0)
 }
 
+func (m *MockRegistry) VisitAllCtx(ctx context.Context, f func(Interface) bool) int {
+	// nolint: typecheck
+	return m.Called(ctx, f).Int(0)
+}
+
 type MockDevice struct {
 	mock.Mock
 }
@@ -1,11 +1,15 @@
 package device
 
 import (
+	"compress/flate"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/metrics/provider"
 	"github.com/gorilla/websocket"
 	"github.com/xmidt-org/sallust"
+	"github.com/xmidt-org/webpa-common/v2/convey/conveyhttp"
+	"github.com/xmidt-org/webpa-common/v2/convey/conveymetric"
 	"go.uber.org/zap"
 )
 
@@ -23,22 +27,61 @@ const (
 	// ConveyHeader is the name of the optional HTTP header which contains the encoded convey JSON.
 	ConveyHeader = "X-Webpa-Convey"
 
+	// ConveyComplianceHeader is the name of the HTTP response header which reports the compliance of
+	// a connecting device's convey data, e.g. "full" or "missing-convey".  See Options.ReportConveyCompliance.
+	ConveyComplianceHeader = "X-Webpa-Convey-Compliance"
+
 	DefaultIdlePeriod     time.Duration = 135 * time.Second
 	DefaultRequestTimeout time.Duration = 30 * time.Second
 	DefaultWriteTimeout   time.Duration = 60 * time.Second
 	DefaultPingPeriod     time.Duration = 45 * time.Second
 
+	// DefaultRejectRetryAfter is the default delay suggested to devices that are filtered out,
+	// rate limited, or turned away because the maximum number of devices are connected.
+	DefaultRejectRetryAfter time.Duration = 30 * time.Second
+
+	// MaxPingPayloadSize is the largest ping payload allowed by RFC 6455 for control frames
+	MaxPingPayloadSize = 125
+
 	DefaultReadBufferSize         = 0
 	DefaultWriteBufferSize        = 0
 	DefaultDeviceMessageQueueSize = 100
+
+	// DefaultCompressionLevel is the flate compression level used for device connections
+	// when compression is enabled and no level is configured.
+	DefaultCompressionLevel = flate.BestSpeed
+)
+
+// Overflow policies for device message queues
+const (
+	// QueueOverflowBlock causes senders to wait until there is room in a device's queue
+	// or until the request's context is canceled.  This is the default.
+	QueueOverflowBlock QueueOverflowPolicy = "block"
+
+	// QueueOverflowDropNewest causes a send to a full device queue to fail immediately
+	// with ErrorDeviceQueueFull, which MessageHandler reports as a 503.
+	QueueOverflowDropNewest QueueOverflowPolicy = "drop-newest"
+
+	// QueueOverflowDropOldest discards the message at the head of a full device queue to make
+	// room for the new message.  A MessageFailed event is dispatched for the discarded message.
+	QueueOverflowDropOldest QueueOverflowPolicy = "drop-oldest"
 )
 
 // WRPSourceCheckType is used to define the different modes
 // in which the source check can run.
 type WRPSourceCheckType string
 
+// QueueOverflowPolicy determines what happens when a message is sent to a device
+// whose message queue is full.
+type QueueOverflowPolicy string
+
 type wrpSourceCheckConfig struct {
 	Type WRPSourceCheckType
+
+	// RespondOnReject causes a WRP response with a 403 status to be sent back to the device when
+	// an enforced source check drops one of its transaction messages.  Without it, rejected messages
+	// are dropped silently.
+	RespondOnReject bool
 }
 
 // Options represent the available configuration options for components
@@ -58,6 +101,16 @@ type Options struct {
 	// PingPeriod is the time between pings sent to each device
 	PingPeriod time.Duration
 
+	// PingJitter is the fraction of PingPeriod by which each connection's ping period is randomly
+	// varied, so that devices connected at the same time are not all pinged together.  For example,
+	// a jitter of 0.1 gives each connection a ping period between 90% and 110% of PingPeriod.
+	// Values outside of (0, 1) disable jitter.
+	PingJitter float64
+
+	// PingPayload is the application data sent with each ping.  If unset, or if longer than
+	// MaxPingPayloadSize, the device ID is sent.
+	PingPayload []byte
+
 	// IdlePeriod is the length of time a device connection is allowed to be idle,
 	// with no traffic coming from the device.  If not supplied, DefaultIdlePeriod is used.
 	IdlePeriod time.Duration
@@ -85,17 +138,93 @@ type Options struct {
 	// 2) Canonical ID can't be parsed from Source.
 	// 3) Canonical ID doesn't match that of the established websocket connection.
 	// Note: when the check type is "monitor", no messages are dropped but they are logged as an error and update the "wrp_source_check"
-	// counter.
+	// counter.  When the check type is "enforce", RespondOnReject lets the device know its message was dropped.
 	WRPSourceCheck wrpSourceCheckConfig
 
+	// EnforceWRPDestination requires that the destination of each message sent to a device
+	// parses to that device's ID.  Messages with a mismatched, missing, or unparseable destination are
+	// rejected with ErrorDestinationMismatch rather than being delivered.  By default, no check is made.
+	EnforceWRPDestination bool
+
+	// RejectEmptyWRPSource drops messages from devices that have an empty Source, even when
+	// the WRPSourceCheck type is "monitor".  Other source check failures are unaffected.
+	RejectEmptyWRPSource bool
+
 	// Filter determines whether or not a device should be able to connect to talaria based on the filters in place
 	Filter Filter
+
+	// Compression enables RFC 7692 permessage-deflate negotiation for device websockets.  When true,
+	// this overrides Upgrader.EnableCompression.  Compression is only used when the device also
+	// requests it during the upgrade.
+	Compression bool
+
+	// CompressionLevel is the flate compression level applied to each device connection when
+	// Compression is enabled.  If unset, DefaultCompressionLevel is used.  This is a pointer so that
+	// flate.NoCompression, which is zero, can be distinguished from an unset level.
+	CompressionLevel *int
+
+	// Subprotocols are the websocket subprotocols, e.g. WRP versions such as "wrp-0.1", supported by
+	// device connections in order of preference.  When set, this overrides Upgrader.Subprotocols, and a
+	// device that requests subprotocols but none of these is rejected.  Devices that request no subprotocol
+	// are always allowed to connect.
+	Subprotocols []string
+
+	// AllowTextFrames enables devices to send WRP messages as JSON in websocket text frames.  Such
+	// messages are re-encoded as Msgpack, so they are indistinguishable from binary frames once received.
+	// If unset, only binary Msgpack frames are accepted and text frames are skipped.
+	AllowTextFrames bool
+
+	// ReportConveyCompliance causes the compliance of each connecting device's convey data to be written
+	// to the ConveyComplianceHeader of the connect response.  If unset, no such header is written.
+	ReportConveyCompliance bool
+
+	// GzipConvey allows devices to send a convey header whose JSON was gzip-compressed prior to
+	// base64 encoding.  Uncompressed convey headers are always accepted.
+	GzipConvey bool
+
+	// ConveyMetricPairs are additional convey tags to track in the hardware_model gauge, such as a device's
+	// manufacturer.  These are appended to the default "hw-model" and "fw-name" pairs.  Each label must also be
+	// declared in the gauge's label names, which MetricsWithConveyPairs does when given these same pairs.
+	ConveyMetricPairs []conveymetric.TagLabelPair
+
+	// ConnectRate is the maximum sustained number of device connections per second allowed by a Manager.
+	// Connections beyond this rate are rejected with a *RetryError wrapping ErrorConnectRateLimited.  If unset,
+	// connections are not rate limited.
+	ConnectRate float64
+
+	// ConnectBurst is the number of connections allowed in a burst above ConnectRate.  If unset, the burst
+	// is ConnectRate rounded up to a whole connection.
+	ConnectBurst int
+
+	// RejectRetryAfter is the delay suggested to devices that are filtered out, rate limited by ConnectRate, or turned
+	// away because MaxDevices are connected.  Such devices are rejected with a *RetryError.  If unset, DefaultRejectRetryAfter is used.
+	RejectRetryAfter time.Duration
+
+	// QueueOverflowPolicy determines how sends to a device with a full message queue are handled.
+	// If unset or unrecognized, QueueOverflowBlock is used.
+	QueueOverflowPolicy QueueOverflowPolicy
+
+	// QueueHighWater is the number of pending messages at which a QueueHighWater event is dispatched
+	// for a device.  A QueueRecovered event follows once the queue drops back below this mark.
+	// If unset or nonpositive, no queue depth events are dispatched.
+	QueueHighWater int
+
+	// PoolFrameBuffers causes the buffers used to encode outbound WRP frames to be pooled and reused
+	// across all devices, rather than allocated for each message.
+	PoolFrameBuffers bool
 }
 
 func (o *Options) upgrader() *websocket.Upgrader {
 	upgrader := new(websocket.Upgrader)
 	if o != nil {
 		*upgrader = o.Upgrader
+		if o.Compression {
+			upgrader.EnableCompression = true
+		}
+
+		if len(o.Subprotocols) > 0 {
+			upgrader.Subprotocols = o.Subprotocols
+		}
 	}
 
 	return upgrader
@@ -109,6 +238,109 @@ func (o *Options) deviceMessageQueueSize() int {
 	return DefaultDeviceMessageQueueSize
 }
 
+func (o *Options) subprotocols() []string {
+	if o != nil {
+		return o.Subprotocols
+	}
+
+	return nil
+}
+
+func (o *Options) allowTextFrames() bool {
+	return o != nil && o.AllowTextFrames
+}
+
+func (o *Options) reportConveyCompliance() bool {
+	return o != nil && o.ReportConveyCompliance
+}
+
+func (o *Options) conveyTranslator() conveyhttp.HeaderTranslator {
+	if o != nil && o.GzipConvey {
+		return conveyhttp.NewHeaderTranslator("", nil, conveyhttp.WithGzip(nil))
+	}
+
+	return conveyhttp.NewHeaderTranslator("", nil)
+}
+
+func (o *Options) conveyMetricPairs() []conveymetric.TagLabelPair {
+	pairs := []conveymetric.TagLabelPair{
+		{
+			Tag:   "hw-model",
+			Label: "model",
+		},
+		{
+			Tag:   "fw-name",
+			Label: "firmware",
+		},
+	}
+
+	if o != nil {
+		pairs = append(pairs, o.ConveyMetricPairs...)
+	}
+
+	return pairs
+}
+
+func (o *Options) compression() bool {
+	return o != nil && o.Compression
+}
+
+func (o *Options) compressionLevel() int {
+	if o != nil && o.CompressionLevel != nil {
+		return *o.CompressionLevel
+	}
+
+	return DefaultCompressionLevel
+}
+
+func (o *Options) queueOverflowPolicy() QueueOverflowPolicy {
+	if o != nil {
+		switch o.QueueOverflowPolicy {
+		case QueueOverflowDropNewest, QueueOverflowDropOldest:
+			return o.QueueOverflowPolicy
+		}
+	}
+
+	return QueueOverflowBlock
+}
+
+func (o *Options) queueHighWater() int {
+	if o != nil && o.QueueHighWater > 0 {
+		return o.QueueHighWater
+	}
+
+	return 0
+}
+
+// framePool returns the pool of frame encoding buffers, or nil if frame buffers are not pooled
+func (o *Options) framePool() *sync.Pool {
+	if o != nil && o.PoolFrameBuffers {
+		return &sync.Pool{
+			New: func() interface{} {
+				return new([]byte)
+			},
+		}
+	}
+
+	return nil
+}
+
+func (o *Options) rejectRetryAfter() time.Duration {
+	if o != nil && o.RejectRetryAfter > 0 {
+		return o.RejectRetryAfter
+	}
+
+	return DefaultRejectRetryAfter
+}
+
+func (o *Options) connectLimiter() *connectLimiter {
+	if o != nil {
+		return newConnectLimiter(o.ConnectRate, o.ConnectBurst, o.now())
+	}
+
+	return nil
+}
+
 func (o *Options) maxDevices() int {
 	if o != nil && o.MaxDevices > 0 {
 		return o.MaxDevices
@@ -133,6 +365,22 @@ func (o *Options) pingPeriod() time.Duration {
 	return DefaultPingPeriod
 }
 
+func (o *Options) pingJitter() float64 {
+	if o != nil && o.PingJitter > 0 && o.PingJitter < 1 {
+		return o.PingJitter
+	}
+
+	return 0
+}
+
+func (o *Options) pingPayload() []byte {
+	if o != nil && len(o.PingPayload) > 0 && len(o.PingPayload) <= MaxPingPayloadSize {
+		return o.PingPayload
+	}
+
+	return nil
+}
+
 func (o *Options) writeTimeout() time.Duration {
 	if o != nil && o.WriteTimeout > 0 {
 		return o.WriteTimeout
@@ -182,6 +430,14 @@ func (o *Options) filter() Filter {
 	return defaultFilterFunc()
 }
 
+func (o *Options) enforceWRPDestination() bool {
+	return o != nil && o.EnforceWRPDestination
+}
+
+func (o *Options) rejectEmptyWRPSource() bool {
+	return o != nil && o.RejectEmptyWRPSource
+}
+
 func (o *Options) wrpCheck() wrpSourceCheckConfig {
 	if o != nil && oneOf(o.WRPSourceCheck.Type, CheckTypeEnforce, CheckTypeMonitor) {
 		return o.WRPSourceCheck
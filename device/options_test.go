@@ -1,6 +1,7 @@
 package device
 
 import (
+	"compress/flate"
 	"testing"
 	"time"
 
@@ -25,9 +26,60 @@ func TestOptionsDefault(t *testing.T) {
 		assert.NotNil(o.logger())
 		assert.Empty(o.listeners())
 		assert.Equal(provider.NewDiscardProvider(), o.metricsProvider())
+		assert.False(o.compression())
+		assert.False(o.allowTextFrames())
+		assert.Empty(o.subprotocols())
+		assert.Empty(o.upgrader().Subprotocols)
+		assert.Equal(DefaultCompressionLevel, o.compressionLevel())
+		assert.False(o.upgrader().EnableCompression)
+		assert.Equal(QueueOverflowBlock, o.queueOverflowPolicy())
+		assert.False(o.enforceWRPDestination())
+		assert.False(o.rejectEmptyWRPSource())
+		assert.Zero(o.queueHighWater())
+		assert.Nil(o.framePool())
 	}
 }
 
+func TestOptionsQueueOverflowPolicy(t *testing.T) {
+	assert := assert.New(t)
+	for policy, expected := range map[QueueOverflowPolicy]QueueOverflowPolicy{
+		"":                      QueueOverflowBlock,
+		"nosuch":                QueueOverflowBlock,
+		QueueOverflowBlock:      QueueOverflowBlock,
+		QueueOverflowDropNewest: QueueOverflowDropNewest,
+		QueueOverflowDropOldest: QueueOverflowDropOldest,
+	} {
+		o := Options{QueueOverflowPolicy: policy}
+		assert.Equal(expected, o.queueOverflowPolicy())
+	}
+}
+
+func TestOptionsCompression(t *testing.T) {
+	assert := assert.New(t)
+	for _, level := range []int{7, flate.NoCompression} {
+		level := level
+		o := Options{
+			Compression:      true,
+			CompressionLevel: &level,
+		}
+
+		assert.True(o.compression())
+		assert.Equal(level, o.compressionLevel())
+		assert.True(o.upgrader().EnableCompression)
+	}
+}
+
+func TestOptionsSubprotocols(t *testing.T) {
+	assert := assert.New(t)
+	o := Options{
+		Upgrader:     websocket.Upgrader{Subprotocols: []string{"overridden"}},
+		Subprotocols: []string{"wrp-0.2", "wrp-0.1"},
+	}
+
+	assert.Equal([]string{"wrp-0.2", "wrp-0.1"}, o.subprotocols())
+	assert.Equal([]string{"wrp-0.2", "wrp-0.1"}, o.upgrader().Subprotocols)
+}
+
 func TestOptions(t *testing.T) {
 	var (
 		assert                  = assert.New(t)
@@ -71,3 +123,20 @@ func TestOptions(t *testing.T) {
 	assert.Equal(o.Listeners, o.listeners())
 	assert.Equal(expectedMetricsProvider, o.metricsProvider())
 }
+
+func TestOptionsPing(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Options{nil, new(Options), {PingJitter: -0.5}, {PingJitter: 1.0}, {PingPayload: make([]byte, MaxPingPayloadSize+1)}} {
+		assert.Zero(o.pingJitter())
+		assert.Nil(o.pingPayload())
+	}
+
+	o := Options{
+		PingJitter:  0.25,
+		PingPayload: []byte("custom"),
+	}
+
+	assert.Equal(0.25, o.pingJitter())
+	assert.Equal([]byte("custom"), o.pingPayload())
+}
@@ -1,6 +1,7 @@
 package device
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -15,6 +16,9 @@ type registryOptions struct {
 	Limit           int
 	InitialCapacity int
 	Measures        Measures
+
+	// readLocked, if set, is invoked each time getMany acquires the read lock.  It is only used by tests.
+	readLocked func()
 }
 
 // registry is the internal lookup map for devices.  it is bounded by an optional maximum number
@@ -31,6 +35,8 @@ type registry struct {
 	connect      xmetrics.Incrementer
 	disconnect   xmetrics.Adder
 	duplicates   xmetrics.Incrementer
+
+	readLocked func()
 }
 
 func newRegistry(o registryOptions) *registry {
@@ -48,6 +54,7 @@ func newRegistry(o registryOptions) *registry {
 		connect:         o.Measures.Connect,
 		disconnect:      o.Measures.Disconnect,
 		duplicates:      o.Measures.Duplicates,
+		readLocked:      o.readLocked,
 	}
 }
 
@@ -60,6 +67,20 @@ func (r *registry) len() int {
 	return l
 }
 
+// full reports whether adding a device with the given ID would exceed this registry's limit.
+// A device that replaces an existing device with the same ID never exceeds the limit.
+func (r *registry) full(id ID) bool {
+	if r.limit <= 0 {
+		return false
+	}
+
+	defer r.lock.RUnlock()
+	r.lock.RLock()
+
+	_, exists := r.data[id]
+	return !exists && len(r.data) >= r.limit
+}
+
 // add uses a factory function to create a new device atomically with modifying
 // the registry
 func (r *registry) add(newDevice *device) error {
@@ -174,11 +195,20 @@ func (r *registry) removeAll(reason CloseReason) int {
 }
 
 func (r *registry) visit(f func(d *device) bool) int {
+	return r.visitCtx(context.Background(), f)
+}
+
+// visitCtx is like visit, but stops before visiting any further devices once the context is done
+func (r *registry) visitCtx(ctx context.Context, f func(d *device) bool) int {
 	defer r.lock.RUnlock()
 	r.lock.RLock()
 
 	visited := 0
 	for _, d := range r.data {
+		if ctx.Err() != nil {
+			break
+		}
+
 		visited++
 		if !f(d) {
 			break
@@ -188,6 +218,20 @@ func (r *registry) visit(f func(d *device) bool) int {
 	return visited
 }
 
+// update applies the given function to the device with the given ID while holding the write lock.
+// This method returns false if no such device exists.
+func (r *registry) update(id ID, f func(*device)) bool {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+
+	existing, ok := r.data[id]
+	if ok {
+		f(existing)
+	}
+
+	return ok
+}
+
 func (r *registry) get(id ID) (*device, bool) {
 	r.lock.RLock()
 	existing, ok := r.data[id]
@@ -195,3 +239,23 @@ func (r *registry) get(id ID) (*device, bool) {
 
 	return existing, ok
 }
+
+// getMany looks up each of the given IDs under a single read lock.  Only the devices
+// that were found are present in the returned map.
+func (r *registry) getMany(ids []ID) map[ID]*device {
+	found := make(map[ID]*device, len(ids))
+
+	r.lock.RLock()
+	if r.readLocked != nil {
+		r.readLocked()
+	}
+
+	for _, id := range ids {
+		if existing, ok := r.data[id]; ok {
+			found[id] = existing
+		}
+	}
+
+	r.lock.RUnlock()
+	return found
+}
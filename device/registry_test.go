@@ -1,6 +1,7 @@
 package device
 
 import (
+	"context"
 	"strconv"
 	"testing"
 
@@ -350,10 +351,131 @@ func testRegistryVisit(t *testing.T) {
 	p.Assert(t, DuplicatesCounter)(xmetricstest.Value(0.0))
 }
 
+func testRegistryUpdate(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		logger  = sallust.Default()
+
+		p = xmetricstest.NewProvider(nil, Metrics)
+		r = newRegistry(registryOptions{
+			Logger:   logger,
+			Measures: NewMeasures(p),
+		})
+	)
+
+	require.NotNil(r)
+
+	initial := newDevice(deviceOptions{
+		ID:     ID("test"),
+		Logger: logger,
+	})
+
+	require.NoError(r.add(initial))
+
+	assert.False(r.update(ID("nosuch"), func(*device) {
+		assert.Fail("The update function should not have been called")
+	}))
+
+	updateCalled := false
+	assert.True(r.update(ID("test"), func(actual *device) {
+		updateCalled = true
+		assert.True(actual == initial)
+	}))
+
+	assert.True(updateCalled)
+	assert.False(initial.Closed())
+	p.Assert(t, DeviceCounter)(xmetricstest.Value(1.0))
+}
+
+func testRegistryGetMany(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		logger  = sallust.Default()
+
+		readLocks int
+
+		r = newRegistry(registryOptions{
+			Logger:     logger,
+			Measures:   NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+			readLocked: func() { readLocks++ },
+		})
+	)
+
+	require.NotNil(r)
+	for i := 0; i < 5; i++ {
+		require.NoError(r.add(newDevice(deviceOptions{
+			ID:     ID(strconv.Itoa(i)),
+			Logger: logger,
+		})))
+	}
+
+	found := r.getMany([]ID{"0", "2", "nosuch", "4", "another"})
+	assert.Equal(1, readLocks)
+	require.Len(found, 3)
+	for _, id := range []ID{"0", "2", "4"} {
+		d, ok := found[id]
+		require.True(ok)
+		assert.Equal(id, d.ID())
+	}
+
+	assert.Empty(r.getMany(nil))
+	assert.NotNil(r.getMany(nil))
+}
+
+func testRegistryVisitCtx(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		logger  = sallust.Default()
+
+		r = newRegistry(registryOptions{
+			Logger:   logger,
+			Measures: NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+		})
+
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+
+	defer cancel()
+	require.NotNil(r)
+	for i := 0; i < 10; i++ {
+		require.NoError(r.add(newDevice(deviceOptions{
+			ID:     ID(strconv.Itoa(i)),
+			Logger: logger,
+		})))
+	}
+
+	visitCalls := 0
+	assert.Equal(
+		3,
+		r.visitCtx(ctx, func(*device) bool {
+			visitCalls++
+			if visitCalls == 3 {
+				cancel()
+			}
+
+			return true
+		}),
+	)
+
+	assert.Equal(3, visitCalls)
+	assert.Zero(r.visitCtx(ctx, func(*device) bool {
+		assert.Fail("The visitor should not be called with a canceled context")
+		return true
+	}))
+
+	assert.Equal(10, r.visitCtx(context.Background(), func(*device) bool { return true }))
+}
+
 func TestRegistry(t *testing.T) {
 	t.Run("Add", testRegistryAdd)
 	t.Run("RemoveAndGet", testRegistryRemoveAndGet)
 	t.Run("RemoveIf", testRegistryRemoveIf)
 	t.Run("RemoveAll", testRegistryRemoveAll)
 	t.Run("Visit", testRegistryVisit)
+	t.Run("Update", testRegistryUpdate)
+	t.Run("GetMany", testRegistryGetMany)
+	t.Run("VisitCtx", testRegistryVisitCtx)
 }
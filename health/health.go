@@ -3,13 +3,33 @@ package health
 import (
 	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+const (
+	// StatusReady is the status of a structured report when the application is ready to receive traffic
+	StatusReady = "ready"
+
+	// StatusNotReady is the status of a structured report when the application is not ready to receive traffic
+	StatusNotReady = "notReady"
+)
+
+// Report is the structured JSON form of the health statistics
+type Report struct {
+	// Status is the overall status, either StatusReady or StatusNotReady
+	Status string `json:"status"`
+
+	// Stats holds the current value of each statistic
+	Stats Stats `json:"stats"`
+}
+
 // StatsListener receives Stats on regular intervals.
 type StatsListener interface {
 	// OnStats is called with a copy of the health's stats map
@@ -49,6 +69,11 @@ type Health struct {
 	statsListeners   []StatsListener
 	memInfoReader    *MemInfoReader
 	once             sync.Once
+
+	// notReady is stored inverted so that the zero value of a Health is ready
+	notReady atomic.Bool
+
+	structured atomic.Bool
 }
 
 var _ Monitor = (*Health)(nil)
@@ -131,6 +156,7 @@ func (h *Health) Run(waitGroup *sync.WaitGroup, shutdown <-chan struct{}) error
 				case <-ticker.C:
 					h.lock.Lock()
 					h.stats.UpdateMemory(h.memInfoReader)
+					h.stats.UpdateGoroutines()
 					dispatchStats := h.stats.Clone()
 					h.lock.Unlock()
 					for _, statsListener := range h.statsListeners {
@@ -144,15 +170,46 @@ func (h *Health) Run(waitGroup *sync.WaitGroup, shutdown <-chan struct{}) error
 	return nil
 }
 
+// SetStructuredJSON changes whether ServeHTTP always writes a structured Report.  When false, which is the
+// default, a Report is only written to clients that explicitly accept application/json.  Other clients receive
+// the flat map of statistics.
+func (h *Health) SetStructuredJSON(structured bool) {
+	h.structured.Store(structured)
+}
+
+// acceptsJSON tests if a request explicitly lists application/json in its Accept header.  Wildcards do not count.
+func acceptsJSON(request *http.Request) bool {
+	for _, accept := range request.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(mediaRange); err == nil && mediaType == "application/json" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (h *Health) ServeHTTP(response http.ResponseWriter, request *http.Request) {
 	var (
-		data []byte
-		err  error
+		data       []byte
+		err        error
+		structured = h.structured.Load() || acceptsJSON(request)
 	)
 
 	h.SendEvent(func(stats Stats) {
 		stats.UpdateMemory(h.memInfoReader)
-		data, err = json.Marshal(stats)
+		stats.UpdateGoroutines()
+		if structured {
+			report := Report{Status: StatusReady, Stats: stats}
+			if !h.Ready() {
+				report.Status = StatusNotReady
+			}
+
+			data, err = json.Marshal(report)
+		} else {
+			data, err = json.Marshal(stats)
+		}
 	})
 
 	response.Header().Set("Content-Type", "application/json")
@@ -164,3 +221,35 @@ func (h *Health) ServeHTTP(response http.ResponseWriter, request *http.Request)
 		fmt.Fprintf(response, "%s", data)
 	}
 }
+
+// SetReady changes whether this Health reports the application as ready to receive traffic.
+// A Health is ready when it is created.  Readiness is typically withdrawn during shutdown or
+// while draining, which does not affect liveness.
+func (h *Health) SetReady(ready bool) {
+	h.notReady.Store(!ready)
+}
+
+// Ready returns whether this Health reports the application as ready to receive traffic.
+func (h *Health) Ready() bool {
+	return !h.notReady.Load()
+}
+
+// LiveHandler returns an http.Handler suitable for a liveness probe.  The returned handler
+// always responds with http.StatusOK.
+func (h *Health) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		response.WriteHeader(http.StatusOK)
+	})
+}
+
+// ReadyHandler returns an http.Handler suitable for a readiness probe.  The returned handler
+// responds with http.StatusOK when this Health is ready and http.StatusServiceUnavailable otherwise.
+func (h *Health) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		if h.Ready() {
+			response.WriteHeader(http.StatusOK)
+		} else {
+			response.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
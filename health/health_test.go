@@ -132,6 +132,101 @@ func TestServeHTTP(t *testing.T) {
 	}
 }
 
+func TestGoroutineCount(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		h        = New(10*time.Millisecond, sallust.Default(), GoroutineCount)
+		shutdown = make(chan struct{})
+		received = make(chan Stats, 1)
+	)
+
+	h.AddStatsListener(StatsListenerFunc(func(stats Stats) {
+		select {
+		case received <- stats:
+		default:
+		}
+	}))
+
+	h.Run(&sync.WaitGroup{}, shutdown)
+	defer close(shutdown)
+
+	select {
+	case stats := <-received:
+		assert.Positive(stats[GoroutineCount])
+	case <-time.After(10 * time.Second):
+		assert.Fail("No stats were dispatched within the timeout")
+	}
+
+	// block some goroutines so that the served count reflects them
+	release := make(chan struct{})
+	defer close(release)
+	for i := 0; i < 10; i++ {
+		go func() { <-release }()
+	}
+
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest("GET", "/health", nil))
+
+	var result Stats
+	assert.NoError(json.Unmarshal(response.Body.Bytes(), &result))
+	assert.GreaterOrEqual(result[GoroutineCount], 10)
+}
+
+func TestServeHTTPStructured(t *testing.T) {
+	testData := []struct {
+		description        string
+		accept             []string
+		structuredJSON     bool
+		ready              bool
+		expectedStructured bool
+		expectedStatus     string
+	}{
+		{description: "NoAccept", ready: true},
+		{description: "Wildcard", accept: []string{"*/*"}, ready: true},
+		{description: "AcceptJSON", accept: []string{"application/json"}, ready: true, expectedStructured: true, expectedStatus: StatusReady},
+		{description: "AcceptList", accept: []string{"text/html, application/json;q=0.9"}, ready: true, expectedStructured: true, expectedStatus: StatusReady},
+		{description: "AcceptNotReady", accept: []string{"application/json"}, expectedStructured: true, expectedStatus: StatusNotReady},
+		{description: "Configured", structuredJSON: true, ready: true, expectedStructured: true, expectedStatus: StatusReady},
+	}
+
+	for _, record := range testData {
+		t.Run(record.description, func(t *testing.T) {
+			var (
+				assert   = assert.New(t)
+				h        = New(time.Minute, sallust.Default(), GoroutineCount)
+				request  = httptest.NewRequest("GET", "/health", nil)
+				response = httptest.NewRecorder()
+			)
+
+			for _, accept := range record.accept {
+				request.Header.Add("Accept", accept)
+			}
+
+			h.SetStructuredJSON(record.structuredJSON)
+			h.SetReady(record.ready)
+			h.ServeHTTP(response, request)
+			assert.Equal(http.StatusOK, response.Code)
+			assert.Equal("application/json", response.Header().Get("Content-Type"))
+
+			var result map[string]interface{}
+			assert.NoError(json.Unmarshal(response.Body.Bytes(), &result))
+			if !record.expectedStructured {
+				assert.Contains(result, string(TotalRequestsReceived))
+				assert.NotContains(result, "status")
+				return
+			}
+
+			assert.Equal(record.expectedStatus, result["status"])
+			stats, ok := result["stats"].(map[string]interface{})
+			if assert.True(ok) {
+				for _, stat := range []Stat{TotalRequestsReceived, CurrentMemoryUtilizationAlloc, GoroutineCount} {
+					assert.Contains(stats, string(stat))
+				}
+			}
+		})
+	}
+}
+
 func TestHealthRequestTracker(t *testing.T) {
 	var (
 		assert   = assert.New(t)
@@ -235,3 +330,30 @@ func TestHealthRequestTrackerDelegatePanic(t *testing.T) {
 	assertionWaitGroup.Wait()
 	handler.AssertExpectations(t)
 }
+
+func TestHealthReadiness(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		h      = setupHealth(t)
+
+		serve = func(handler http.Handler) int {
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+			return response.Code
+		}
+	)
+
+	assert.True(h.Ready())
+	assert.Equal(http.StatusOK, serve(h.LiveHandler()))
+	assert.Equal(http.StatusOK, serve(h.ReadyHandler()))
+
+	h.SetReady(false)
+	assert.False(h.Ready())
+	assert.Equal(http.StatusOK, serve(h.LiveHandler()))
+	assert.Equal(http.StatusServiceUnavailable, serve(h.ReadyHandler()))
+
+	h.SetReady(true)
+	assert.True(h.Ready())
+	assert.Equal(http.StatusOK, serve(h.LiveHandler()))
+	assert.Equal(http.StatusOK, serve(h.ReadyHandler()))
+}
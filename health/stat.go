@@ -17,6 +17,10 @@ const (
 	TotalRequestsReceived             Stat = "TotalRequestsReceived"
 	TotalRequestsSuccessfullyServiced Stat = "TotalRequestsSuccessfullyServiced"
 	TotalRequestsDenied               Stat = "TotalRequestsDenied"
+
+	// GoroutineCount is the number of goroutines that currently exist.  Unlike the other stats,
+	// this stat is not added by default.  Pass it as an Option to track it.
+	GoroutineCount Stat = "GoroutineCount"
 )
 
 var (
@@ -156,6 +160,14 @@ func (s Stats) UpdateMemStats(memStats *runtime.MemStats) {
 	}
 }
 
+// UpdateGoroutines sets the GoroutineCount stat to the current number of goroutines.
+// Nothing is updated unless the GoroutineCount stat is being tracked.
+func (s Stats) UpdateGoroutines() {
+	if _, ok := s[GoroutineCount]; ok {
+		s[GoroutineCount] = runtime.NumGoroutine()
+	}
+}
+
 // UpdateMemory updates all the memory statistics
 func (s Stats) UpdateMemory(memInfoReader *MemInfoReader) {
 	memInfo, err := memInfoReader.Read()
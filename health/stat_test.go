@@ -334,3 +334,21 @@ func TestUpdateMemory(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateGoroutines(t *testing.T) {
+	untracked := NewStats(nil)
+	untracked.UpdateGoroutines()
+	if _, ok := untracked[GoroutineCount]; ok {
+		t.Errorf("%s should not be added when it is not tracked", GoroutineCount)
+	}
+
+	tracked := NewStats([]Option{GoroutineCount})
+	if tracked[GoroutineCount] != 0 {
+		t.Errorf("%s should be initialized to 0", GoroutineCount)
+	}
+
+	tracked.UpdateGoroutines()
+	if tracked[GoroutineCount] < 1 {
+		t.Errorf("Expected a positive %s, got %d", GoroutineCount, tracked[GoroutineCount])
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// certificateWatcher serves a set of TLS certificates that are reloaded from disk
+// whenever the directories holding the certificate or key files change.
+type certificateWatcher struct {
+	logger           *zap.Logger
+	certificateFiles []string
+	keyFiles         []string
+	watcher          *fsnotify.Watcher
+
+	lock  sync.RWMutex
+	certs []tls.Certificate
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// newCertificateWatcher loads the given certificates and starts watching them for changes.
+// Directories are watched rather than files so that atomic renames, such as the symlink
+// swaps performed by Kubernetes secrets, are detected.
+func newCertificateWatcher(logger *zap.Logger, certificateFiles, keyFiles []string) (*certificateWatcher, error) {
+	certs, err := loadCerts(certificateFiles, keyFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	directories := make(map[string]bool)
+	for _, f := range append(append([]string{}, certificateFiles...), keyFiles...) {
+		directory := filepath.Dir(f)
+		if directories[directory] {
+			continue
+		}
+
+		if err := watcher.Add(directory); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+
+		directories[directory] = true
+	}
+
+	cw := &certificateWatcher{
+		logger:           logger,
+		certificateFiles: certificateFiles,
+		keyFiles:         keyFiles,
+		watcher:          watcher,
+		certs:            certs,
+		done:             make(chan struct{}),
+	}
+
+	go cw.watch()
+	return cw, nil
+}
+
+// watch reloads the certificates on each file system event until this watcher is closed
+func (cw *certificateWatcher) watch() {
+	defer close(cw.done)
+	for {
+		select {
+		case event, ok := <-cw.watcher.Events:
+			if !ok {
+				return
+			}
+
+			cw.logger.Debug("certificate directory changed", zap.String("name", event.Name), zap.String("op", event.Op.String()))
+			cw.reload()
+
+		case err, ok := <-cw.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			cw.logger.Error("certificate watcher error", zap.Error(err))
+		}
+	}
+}
+
+// reload loads the certificates from disk.  If loading fails, for example because only one of a
+// certificate and key pair has been written so far, the current certificates continue to be served.
+func (cw *certificateWatcher) reload() {
+	certs, err := loadCerts(cw.certificateFiles, cw.keyFiles)
+	if err != nil {
+		cw.logger.Error("unable to reload certificates", zap.Error(err))
+		return
+	}
+
+	defer cw.lock.Unlock()
+	cw.lock.Lock()
+	cw.certs = certs
+	cw.logger.Info("reloaded certificates", zap.Strings("certificateFiles", cw.certificateFiles))
+}
+
+// GetCertificate is a tls.Config.GetCertificate callback.  The first certificate supported by
+// the client is returned, falling back to the first certificate as the tls package does.
+func (cw *certificateWatcher) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	defer cw.lock.RUnlock()
+	cw.lock.RLock()
+
+	for i := range cw.certs {
+		if hello.SupportsCertificate(&cw.certs[i]) == nil {
+			return &cw.certs[i], nil
+		}
+	}
+
+	return &cw.certs[0], nil
+}
+
+// Close stops watching the certificate files.  This method is idempotent.
+func (cw *certificateWatcher) Close() {
+	cw.closeOnce.Do(func() {
+		cw.watcher.Close()
+		<-cw.done
+	})
+}
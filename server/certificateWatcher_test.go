@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/sallust"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics"
+	"go.uber.org/zap/zapcore"
+)
+
+// writeTestCertificate generates a self-signed certificate, writes the PEM-encoded certificate
+// and key to the given files, and returns the DER bytes of the certificate.
+func writeTestCertificate(t *testing.T, serialNumber int64, certificateFile, keyFile string) []byte {
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{Organization: []string{"Test"}},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(err)
+
+	require.NoError(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	require.NoError(os.WriteFile(certificateFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return der
+}
+
+// servedCertificate returns the DER bytes of the certificate presented on a fresh TLS connection
+func servedCertificate(address string) ([]byte, error) {
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true}) // nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Raw, nil
+}
+
+func TestBasicWatchCertificates(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		directory       = t.TempDir()
+		certificateFile = filepath.Join(directory, "cert.pem")
+		keyFile         = filepath.Join(directory, "key.pem")
+
+		original = writeTestCertificate(t, 1, certificateFile, keyFile)
+
+		basic = Basic{
+			Name:              "TestBasicWatchCertificates",
+			Address:           "127.0.0.1:0",
+			CertificateFile:   []string{certificateFile},
+			KeyFile:           []string{keyFile},
+			WatchCertificates: true,
+		}
+	)
+
+	server := basic.New(logger, http.NotFoundHandler())
+	require.NotNil(server)
+	require.NotNil(server.TLSConfig.GetCertificate)
+	assert.Empty(server.TLSConfig.Certificates)
+
+	listener, err := net.Listen("tcp", basic.Address)
+	require.NoError(err)
+
+	go server.ServeTLS(listener, "", "")
+	defer server.Shutdown(context.Background())
+
+	served, err := servedCertificate(listener.Addr().String())
+	require.NoError(err)
+	assert.Equal(original, served)
+
+	rotated := writeTestCertificate(t, 2, certificateFile, keyFile)
+	assert.Eventually(
+		func() bool {
+			served, err := servedCertificate(listener.Addr().String())
+			return err == nil && bytes.Equal(rotated, served)
+		},
+		5*time.Second,
+		50*time.Millisecond,
+	)
+}
+
+func TestWebPAShutdownServerClosesCertificateWatcher(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		directory       = t.TempDir()
+		certificateFile = filepath.Join(directory, "cert.pem")
+		keyFile         = filepath.Join(directory, "key.pem")
+
+		// no ShutdownTimeout, so the server is closed immediately
+		webPA = WebPA{}
+		basic = Basic{
+			Name:              "TestWebPAShutdownServerClosesCertificateWatcher",
+			Address:           "127.0.0.1:0",
+			CertificateFile:   []string{certificateFile},
+			KeyFile:           []string{keyFile},
+			WatchCertificates: true,
+		}
+	)
+
+	writeTestCertificate(t, 1, certificateFile, keyFile)
+
+	server, watcher := basic.newServer(logger, http.NotFoundHandler())
+	require.NotNil(server)
+	require.NotNil(watcher)
+
+	listener, err := basic.NewListener(logger, nil, nil, server.TLSConfig)
+	require.NoError(err)
+	go server.Serve(listener)
+
+	_, err = servedCertificate(listener.Addr().String())
+	require.NoError(err)
+
+	assert.NoError(webPA.shutdownServer(logger, server, listener, watcher))
+	select {
+	case <-watcher.done:
+	default:
+		assert.Fail("the certificate watcher was not closed")
+	}
+}
+
+func TestWebPAPrepareClosesCertificateWatcherOnError(t *testing.T) {
+	var (
+		assert         = assert.New(t)
+		require        = require.New(t)
+		output, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		directory       = t.TempDir()
+		certificateFile = filepath.Join(directory, "cert.pem")
+		keyFile         = filepath.Join(directory, "key.pem")
+	)
+
+	writeTestCertificate(t, 1, certificateFile, keyFile)
+
+	// occupy the alternate address so that its listener fails after the primary's has been created
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer occupied.Close()
+
+	var (
+		webPA = WebPA{
+			Primary: Basic{
+				Name:              "TestWebPAPrepareClosesCertificateWatcherOnError",
+				Address:           "127.0.0.1:0",
+				CertificateFile:   []string{certificateFile},
+				KeyFile:           []string{keyFile},
+				WatchCertificates: true,
+			},
+			Alternate: Basic{
+				Name:    "TestWebPAPrepareClosesCertificateWatcherOnError.alternate",
+				Address: occupied.Addr().String(),
+			},
+		}
+
+		_, runnable, done = webPA.Prepare(logger, nil, xmetrics.MustNewRegistry(nil), http.NotFoundHandler())
+		shutdown          = make(chan struct{})
+	)
+
+	defer close(shutdown)
+	assert.Error(runnable.Run(new(sync.WaitGroup), shutdown))
+
+	select {
+	case <-done:
+	default:
+		assert.Fail("the done channel was not closed")
+	}
+
+	// a closed watcher no longer reloads the certificates when they change
+	writeTestCertificate(t, 2, certificateFile, keyFile)
+	time.Sleep(500 * time.Millisecond)
+	assert.NotContains(output.String(), "reloaded certificates")
+}
+
+func TestBasicWatchCertificatesInvalid(t *testing.T) {
+	var (
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		basic = Basic{
+			Name:              "TestBasicWatchCertificatesInvalid",
+			Address:           ":443",
+			CertificateFile:   []string{"missing-cert.pem"},
+			KeyFile:           []string{"missing-key.pem"},
+			WatchCertificates: true,
+		}
+	)
+
+	assert.Nil(t, basic.New(logger, nil))
+}
@@ -39,8 +39,8 @@ func Metrics() []xmetrics.Metric {
 		xmetrics.Metric{
 			Name:       RejectedConnections,
 			Type:       "counter",
-			Help:       "The total number of connections rejected due to exceeding the limit",
-			LabelNames: []string{"server"},
+			Help:       "The total number of connections rejected, labeled by the reason for rejection",
+			LabelNames: []string{"server", "reason"},
 		},
 		xmetrics.Metric{
 			Name:    RequestDurationSeconds,
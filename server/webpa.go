@@ -11,6 +11,7 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/xmidt-org/webpa-common/v2/xlistener"
 	"github.com/xmidt-org/webpa-common/v2/xmetrics"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
 )
 
 const (
@@ -40,6 +42,9 @@ const (
 	DefaultWriteTimeout      time.Duration = 30 * time.Minute
 
 	DefaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+
+	// unixAddressPrefix is the Address prefix that selects a Unix domain socket
+	unixAddressPrefix = "unix://"
 )
 
 var (
@@ -51,6 +56,12 @@ var (
 		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
 		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 	}
+
+	// http2CipherSuites are the additional tls.CipherSuite values required by HTTP/2 for TLS versions less than 1.3
+	http2CipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	}
 )
 
 // executor is an internal type used to start an HTTP server.  *http.Server implements
@@ -112,11 +123,17 @@ func ListenAndServe(logger *zap.Logger, e executor, finalizer func()) {
 // Basic describes a simple HTTP server.  Typically, this struct has its values
 // injected via Viper.  See the New function in this package.
 type Basic struct {
-	Name               string
-	Address            string
+	Name    string
+	Address string
+
+	// Network is the network the server listens on, e.g. "tcp" or "unix".  If unset, the network is taken from
+	// the Address when it has the form unix:///path/to.sock.  Otherwise, "tcp" is used.
+	Network string
+
 	CertificateFile    []string
 	KeyFile            []string
 	ClientCACertFile   string
+	ClientCACertFiles  []string
 	LogConnectionState bool
 	MinVersion         uint16
 	MaxVersion         uint16
@@ -130,6 +147,14 @@ type Basic struct {
 	ReadHeaderTimeout time.Duration
 	ReadTimeout       time.Duration
 	WriteTimeout      time.Duration
+
+	// EnableHTTP2 turns on HTTP/2 support for TLS connections.  By default, servers only speak HTTP/1.1.
+	// This setting has no effect unless a certificate and key are configured, as plaintext servers only speak HTTP/1.1.
+	EnableHTTP2 bool
+
+	// WatchCertificates causes the certificate and key files to be reloaded whenever they change on disk.
+	// New connections use the reloaded certificates.  The file watcher is stopped when the server is shut down.
+	WatchCertificates bool
 }
 
 func (b *Basic) minVersion() uint16 {
@@ -150,6 +175,24 @@ func (b *Basic) maxVersion() uint16 {
 	return 0
 }
 
+// clientCACertFiles returns all the configured client CA files, beginning with ClientCACertFile if it is set
+func (b *Basic) clientCACertFiles() []string {
+	var files []string
+	if b != nil {
+		if len(b.ClientCACertFile) > 0 {
+			files = append(files, b.ClientCACertFile)
+		}
+
+		for _, f := range b.ClientCACertFiles {
+			if len(f) > 0 {
+				files = append(files, f)
+			}
+		}
+	}
+
+	return files
+}
+
 type PeerVerifyCallback func([][]byte, [][]*x509.Certificate) error
 
 func DefaultPeerVerifyCallback(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
@@ -210,14 +253,38 @@ func (b *Basic) SetPeerVerifyCallback(vp PeerVerifyCallback) {
 	b.PeerVerifyFunc = vp
 }
 
-// NewListener creates a decorated TCPListener appropriate for this server's configuration.
+// listenAddress returns the network and address to listen on.  An Address of the form
+// unix:///path/to.sock produces the "unix" network with the path as the address.
+func (b *Basic) listenAddress() (network, address string) {
+	network, address = b.Network, b.Address
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		address = strings.TrimPrefix(address, unixAddressPrefix)
+		if len(network) == 0 {
+			network = "unix"
+		}
+	}
+
+	return
+}
+
+// NewListener creates a decorated net.Listener appropriate for this server's configuration.  By default,
+// this is a TCP listener.  A Unix domain socket listener is created when the Address has the form unix:///path/to.sock.
 func (b *Basic) NewListener(logger *zap.Logger, activeConnections metrics.Gauge, rejectedCounter xmetrics.Adder, config *tls.Config) (net.Listener, error) {
+	return b.newListener(logger, activeConnections, rejectedCounter, false, config)
+}
+
+// newListener is NewListener, with the option to label rejected connections by reason.  The rejected counter
+// must declare xlistener.RejectedReasonLabel when labelRejected is true, as the RejectedConnections metric does.
+func (b *Basic) newListener(logger *zap.Logger, activeConnections metrics.Gauge, rejectedCounter xmetrics.Adder, labelRejected bool, config *tls.Config) (net.Listener, error) {
+	network, address := b.listenAddress()
 	return xlistener.New(xlistener.Options{
 		Logger:         logger,
-		Address:        b.Address,
+		Network:        network,
+		Address:        address,
 		MaxConnections: b.maxConnections(),
 		Active:         activeConnections,
 		Rejected:       rejectedCounter,
+		LabelRejected:  labelRejected,
 		Config:         config,
 	})
 }
@@ -260,37 +327,75 @@ func loadCerts(certificateFiles, keyFiles []string) (certs []tls.Certificate, er
 // This method returns nil if the configured address is empty or if any config errors occur, effectively disabling
 // this server from startup.
 func (b *Basic) New(logger *zap.Logger, handler http.Handler) *http.Server {
+	server, certificateWatcher := b.newServer(logger, handler)
+	if certificateWatcher != nil {
+		server.RegisterOnShutdown(certificateWatcher.Close)
+	}
+
+	return server
+}
+
+// newServer creates the http.Server described by New, along with the certificateWatcher it uses, if any.
+// The caller is responsible for closing the returned certificateWatcher.
+func (b *Basic) newServer(logger *zap.Logger, handler http.Handler) (*http.Server, *certificateWatcher) {
 	if len(b.Address) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	var tlsConfig *tls.Config
-	if len(b.CertificateFile) > 0 && len(b.KeyFile) > 0 {
-		certs, err := loadCerts(b.CertificateFile, b.KeyFile)
-		if err != nil {
-			logger.Error("Error loading cert and key file to configure TLS", zap.Error(err))
-			return nil
-		}
+	var (
+		tlsConfig          *tls.Config
+		certificateWatcher *certificateWatcher
+	)
 
+	if len(b.CertificateFile) > 0 && len(b.KeyFile) > 0 {
 		tlsConfig = &tls.Config{
-			Certificates: certs,
-			MinVersion:   b.minVersion(),
-			MaxVersion:   b.maxVersion(),
+			MinVersion: b.minVersion(),
+			MaxVersion: b.maxVersion(),
 
 			// ensure strong ciphers when the TLS version is 1.2 or less
 			CipherSuites: strongCipherSuites,
 		}
 
-		if len(b.ClientCACertFile) > 0 {
-			caCert, err := ioutil.ReadFile(b.ClientCACertFile)
+		if b.WatchCertificates {
+			var err error
+			certificateWatcher, err = newCertificateWatcher(logger, b.CertificateFile, b.KeyFile)
+			if err != nil {
+				logger.Error("Error watching cert and key file to configure TLS", zap.Error(err))
+				return nil, nil
+			}
 
+			tlsConfig.GetCertificate = certificateWatcher.GetCertificate
+		} else {
+			certs, err := loadCerts(b.CertificateFile, b.KeyFile)
 			if err != nil {
-				logger.Error("Error loading clientCACert file to configure mTLS", zap.Error(err))
-				return nil
+				logger.Error("Error loading cert and key file to configure TLS", zap.Error(err))
+				return nil, nil
 			}
 
+			tlsConfig.Certificates = certs
+		}
+
+		if b.EnableHTTP2 {
+			tlsConfig.CipherSuites = append(append([]uint16{}, strongCipherSuites...), http2CipherSuites...)
+		}
+
+		if clientCACertFiles := b.clientCACertFiles(); len(clientCACertFiles) > 0 {
 			caCertPool := x509.NewCertPool()
-			caCertPool.AppendCertsFromPEM(caCert)
+			for _, clientCACertFile := range clientCACertFiles {
+				caCert, err := ioutil.ReadFile(clientCACertFile)
+
+				if err != nil {
+					logger.Error("Error loading clientCACert file to configure mTLS", zap.String("file", clientCACertFile), zap.Error(err))
+					if certificateWatcher != nil {
+						certificateWatcher.Close()
+					}
+
+					return nil, nil
+				}
+
+				caCertPool.AppendCertsFromPEM(caCert)
+			}
+
 			tlsConfig.ClientCAs = caCertPool
 			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 		}
@@ -306,7 +411,19 @@ func (b *Basic) New(logger *zap.Logger, handler http.Handler) *http.Server {
 		MaxHeaderBytes:    b.maxHeaderBytes(),
 		ErrorLog:          sallust.NewServerLogger(b.Name, logger),
 		TLSConfig:         tlsConfig,
-		TLSNextProto:      map[string]func(*http.Server, *tls.Conn, http.Handler){}, // disable HTTP/2
+	}
+
+	if b.EnableHTTP2 && tlsConfig != nil {
+		if err := http2.ConfigureServer(server, nil); err != nil {
+			logger.Error("Error configuring HTTP/2", zap.Error(err))
+			if certificateWatcher != nil {
+				certificateWatcher.Close()
+			}
+
+			return nil, nil
+		}
+	} else {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){} // disable HTTP/2
 	}
 
 	if b.LogConnectionState {
@@ -317,7 +434,7 @@ func (b *Basic) New(logger *zap.Logger, handler http.Handler) *http.Server {
 		server.SetKeepAlivesEnabled(false)
 	}
 
-	return server
+	return server, certificateWatcher
 }
 
 // Metric is the configurable factory for a metrics server.
@@ -379,6 +496,10 @@ type Health struct {
 	LogConnectionState bool
 	LogInterval        time.Duration
 	Options            []string
+
+	// StructuredJSON causes /health to always respond with a health.Report, rather than only
+	// to clients that explicitly accept application/json.
+	StructuredJSON bool
 }
 
 // NewHealth creates a Health instance from this instance's configuration.  If the Address
@@ -392,17 +513,23 @@ func (h *Health) NewHealth(logger *zap.Logger, options ...health.Option) *health
 		options = append(options, health.Stat(value))
 	}
 
-	return health.New(
+	hlth := health.New(
 		h.LogInterval,
 		logger,
 		options...,
 	)
+
+	hlth.SetStructuredJSON(h.StructuredJSON)
+	return hlth
 }
 
 // New creates an HTTP server instance for serving health statistics.  If the health parameter
 // is nil, then h.NewHealth is used to create a Health instance.  Otherwise, the health parameter
 // is returned as is.
 //
+// Along with the statistics at /health, the server exposes /live and /ready for liveness and
+// readiness probes.  Readiness is controlled via SetReady on the returned Health.
+//
 // If the Address option is not supplied, the health module is considered to be disabled.  In that
 // case, this method simply returns the health parameter as the monitor and a nil server instance.
 func (h *Health) New(logger *zap.Logger, chain alice.Chain, health *health.Health) (*health.Health, *http.Server) {
@@ -421,6 +548,8 @@ func (h *Health) New(logger *zap.Logger, chain alice.Chain, health *health.Healt
 
 	mux := http.NewServeMux()
 	mux.Handle("/health", chain.Then(health))
+	mux.Handle("/live", chain.Then(health.LiveHandler()))
+	mux.Handle("/ready", chain.Then(health.ReadyHandler()))
 
 	server := &http.Server{
 		Addr:              h.Address,
@@ -480,6 +609,10 @@ type WebPA struct {
 
 	// Log is the logging configuration for this application.
 	Log *[]zap.Field
+
+	// ShutdownTimeout is the maximum time to wait for in-flight requests to complete when servers
+	// are finalized.  If unset, servers are closed immediately without waiting.
+	ShutdownTimeout time.Duration
 }
 
 // build returns the injected build string if available, DefaultBuild otherwise
@@ -518,6 +651,39 @@ func (w *WebPA) flavor() string {
 	return DefaultFlavor
 }
 
+// shutdownServer finalizes a single server.  If a ShutdownTimeout is configured, the server is
+// gracefully shut down so that in-flight requests can complete.  If the server's listener is an
+// xlistener.Drainer, it is then drained within the same timeout, since http.Server.Shutdown does not
+// wait on hijacked connections such as websockets.  The server is closed if no timeout is configured
+// or if the graceful shutdown does not complete in time.
+//
+// The server's certificateWatcher, which may be nil, is always closed.  http.Server.Close does not run
+// the hooks registered with RegisterOnShutdown, so the watcher cannot rely on them.
+func (w *WebPA) shutdownServer(logger *zap.Logger, s *http.Server, l net.Listener, cw *certificateWatcher) error {
+	if cw != nil {
+		defer cw.Close()
+	}
+
+	if w == nil || w.ShutdownTimeout <= 0 {
+		return s.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.ShutdownTimeout)
+	defer cancel()
+
+	err := s.Shutdown(ctx)
+	if d, ok := l.(xlistener.Drainer); ok && err == nil {
+		err = d.Drain(ctx)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	logger.Error("graceful shutdown failed, closing server", zap.String("address", s.Addr), zap.Error(err))
+	return s.Close()
+}
+
 // Prepare gets a WebPA server ready for execution.  This method does not return errors, but the returned
 // Runnable may return an error.  The supplied logger will usually come from the New function, but the
 // WebPA.Log object can be used to create a different logger if desired.
@@ -551,13 +717,15 @@ func (w *WebPA) Prepare(logger *zap.Logger, health *health.Health, registry xmet
 		healthHandler, healthServer = w.Health.New(logger, alice.New(staticHeaders), health)
 
 		servers      []*http.Server
+		listeners    = make(map[*http.Server]net.Listener)
+		watchers     = make(map[*http.Server]*certificateWatcher)
 		finalizeOnce sync.Once
 		done         = make(chan struct{})
 		finalizer    = func() {
 			finalizeOnce.Do(func() {
 				defer close(done)
 				for _, s := range servers {
-					logger.Error("finalizing server", zap.Error(s.Close()))
+					logger.Error("finalizing server", zap.Error(w.shutdownServer(logger, s, listeners[s], watchers[s])))
 				}
 			})
 		}
@@ -566,27 +734,42 @@ func (w *WebPA) Prepare(logger *zap.Logger, health *health.Health, registry xmet
 	return healthHandler, concurrent.RunnableFunc(func(waitGroup *sync.WaitGroup, shutdown <-chan struct{}) error {
 		primaryHandler = staticHeaders(w.decorateWithBasicMetrics(registry, primaryHandler))
 
+		// on an early return, no server is ever shut down, so the watchers must be closed here
+		closeWatchers := func() {
+			for _, cw := range watchers {
+				if cw != nil {
+					cw.Close()
+				}
+			}
+		}
+
 		// create all the servers first, so that we can populate the servers slice
 		// without worrying about concurrency
-		primaryServer := w.Primary.New(logger, primaryHandler)
+		primaryServer, primaryWatcher := w.Primary.newServer(logger, primaryHandler)
 		if primaryServer == nil {
 			// the primary server is required
+			closeWatchers()
 			close(done)
 			return ErrorNoPrimaryAddress
 		}
 
-		alternateServer := w.Alternate.New(logger, primaryHandler)
+		servers = append(servers, primaryServer)
+		watchers[primaryServer] = primaryWatcher
+
+		alternateServer, alternateWatcher := w.Alternate.newServer(logger, primaryHandler)
 		if alternateServer != nil {
 			servers = append(servers, alternateServer)
+			watchers[alternateServer] = alternateWatcher
 		}
 
 		if healthServer != nil {
 			servers = append(servers, healthServer)
 		}
 
-		pprofServer := w.Pprof.New(logger, nil)
+		pprofServer, pprofWatcher := w.Pprof.newServer(logger, nil)
 		if pprofServer != nil {
 			servers = append(servers, pprofServer)
+			watchers[pprofServer] = pprofWatcher
 		}
 
 		metricsServer := w.Metric.New(logger, alice.New(staticHeaders), registry)
@@ -597,35 +780,43 @@ func (w *WebPA) Prepare(logger *zap.Logger, health *health.Health, registry xmet
 		// create any necessary listeners first, so that we return early if errors occur
 
 		primaryLogger := logger.With(zap.String("serverName", w.Primary.Name), zap.String("bindAddress", w.Primary.Address))
-		primaryListener, err := w.Primary.NewListener(
+		primaryListener, err := w.Primary.newListener(
 			primaryLogger,
 			activeConnections.With("server", "primary"),
 			rejectedCounter.With("server", "primary"),
+			true,
 			primaryServer.TLSConfig,
 		)
 
 		if err != nil {
+			closeWatchers()
 			close(done)
 			return err
 		}
 
+		listeners[primaryServer] = primaryListener
+
 		// now we can start all the servers
 
 		// start the alternate server first, so we can short-circuit in the case of errors
 		if alternateServer != nil {
 			alternateLogger := logger.With(zap.String("serverName", w.Alternate.Name), zap.String("bindAddress", w.Alternate.Address))
-			alternateListener, err := w.Alternate.NewListener(
+			alternateListener, err := w.Alternate.newListener(
 				alternateLogger,
 				activeConnections.With("server", "alternate"),
 				rejectedCounter.With("server", "alternate"),
+				true,
 				alternateServer.TLSConfig,
 			)
 
 			if err != nil {
+				primaryListener.Close()
+				closeWatchers()
 				close(done)
 				return err
 			}
 
+			listeners[alternateServer] = alternateListener
 			Serve(alternateLogger, alternateListener, alternateServer, finalizer)
 		}
 
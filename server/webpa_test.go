@@ -2,20 +2,36 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"log"
+	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/metrics/generic"
 	"github.com/justinas/alice"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/sallust"
+	"github.com/xmidt-org/webpa-common/v2/health"
+	"github.com/xmidt-org/webpa-common/v2/xlistener"
 	"github.com/xmidt-org/webpa-common/v2/xmetrics"
 	"go.uber.org/zap/zapcore"
 )
@@ -315,6 +331,297 @@ func TestBasicNew(t *testing.T) {
 	}
 }
 
+func testBasicNewProtocol(t *testing.T, enableHTTP2 bool, expectedProtoMajor int) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		basic = Basic{
+			Name:            "TestBasicNewHTTP2",
+			Address:         "127.0.0.1:0",
+			CertificateFile: []string{"cert.pem"},
+			KeyFile:         []string{"key.pem"},
+			EnableHTTP2:     enableHTTP2,
+		}
+
+		handler = http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+			response.WriteHeader(http.StatusNoContent)
+		})
+	)
+
+	server := basic.New(logger, handler)
+	require.NotNil(server)
+	require.NotNil(server.TLSConfig)
+
+	listener, err := basic.NewListener(logger, nil, nil, server.TLSConfig)
+	require.NoError(err)
+
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			// the test certificate is self-signed and expired
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // nolint:gosec
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	response, err := client.Get("https://" + listener.Addr().String())
+	require.NoError(err)
+	defer response.Body.Close()
+
+	assert.Equal(http.StatusNoContent, response.StatusCode)
+	assert.Equal(expectedProtoMajor, response.ProtoMajor)
+}
+
+func testBasicNewHTTP2Plaintext(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		basic = Basic{
+			Name:        "TestBasicNewHTTP2",
+			Address:     "127.0.0.1:0",
+			EnableHTTP2: true,
+		}
+
+		handler = http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+			response.WriteHeader(http.StatusNoContent)
+		})
+	)
+
+	server := basic.New(logger, handler)
+	require.NotNil(server)
+	assert.Nil(server.TLSConfig)
+
+	listener, err := basic.NewListener(logger, nil, nil, server.TLSConfig)
+	require.NoError(err)
+
+	go server.Serve(listener)
+	defer server.Close()
+
+	response, err := http.Get("http://" + listener.Addr().String())
+	require.NoError(err)
+	defer response.Body.Close()
+
+	assert.Equal(http.StatusNoContent, response.StatusCode)
+	assert.Equal(1, response.ProtoMajor)
+}
+
+func TestBasicNewHTTP2(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) { testBasicNewProtocol(t, false, 1) })
+	t.Run("Enabled", func(t *testing.T) { testBasicNewProtocol(t, true, 2) })
+	t.Run("Plaintext", testBasicNewHTTP2Plaintext)
+}
+
+// newTestClientCA generates a certificate authority, writes it as PEM to the given file, and
+// returns a client certificate issued by that authority.
+func newTestClientCA(t *testing.T, serialNumber int64, caFile string) tls.Certificate {
+	require := require.New(t)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(serialNumber),
+		Subject:               pkix.Name{CommonName: filepath.Base(caFile)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(err)
+	require.NoError(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	clientTemplate := x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber + 1000),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	clientDER, err := x509.CreateCertificate(rand.Reader, &clientTemplate, ca, &clientKey.PublicKey, caKey)
+	require.NoError(err)
+
+	return tls.Certificate{
+		Certificate: [][]byte{clientDER},
+		PrivateKey:  clientKey,
+	}
+}
+
+func testBasicNewClientCACertFiles(t *testing.T, useSingleFile bool) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		directory = t.TempDir()
+		ca1File   = filepath.Join(directory, "ca1.pem")
+		ca2File   = filepath.Join(directory, "ca2.pem")
+		ca3File   = filepath.Join(directory, "ca3.pem")
+
+		client1 = newTestClientCA(t, 1, ca1File)
+		client2 = newTestClientCA(t, 2, ca2File)
+		client3 = newTestClientCA(t, 3, ca3File)
+
+		basic = Basic{
+			Name:            "TestBasicNewClientCACertFiles",
+			Address:         "127.0.0.1:0",
+			CertificateFile: []string{"cert.pem"},
+			KeyFile:         []string{"key.pem"},
+		}
+	)
+
+	if useSingleFile {
+		basic.ClientCACertFile = ca1File
+		basic.ClientCACertFiles = []string{ca2File}
+	} else {
+		basic.ClientCACertFiles = []string{ca1File, ca2File}
+	}
+
+	server := basic.New(logger, http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		response.WriteHeader(http.StatusNoContent)
+	}))
+
+	require.NotNil(server)
+	require.NotNil(server.TLSConfig.ClientCAs)
+	assert.Equal(tls.RequireAndVerifyClientCert, server.TLSConfig.ClientAuth)
+
+	listener, err := net.Listen("tcp", basic.Address)
+	require.NoError(err)
+
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	get := func(clientCert tls.Certificate) error {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					Certificates:       []tls.Certificate{clientCert},
+					InsecureSkipVerify: true, // nolint:gosec
+				},
+			},
+		}
+
+		response, err := client.Get("https://" + listener.Addr().String())
+		if err == nil {
+			response.Body.Close()
+		}
+
+		return err
+	}
+
+	assert.NoError(get(client1))
+	assert.NoError(get(client2))
+	assert.Error(get(client3))
+}
+
+func TestBasicNewClientCACertFiles(t *testing.T) {
+	t.Run("Multiple", func(t *testing.T) { testBasicNewClientCACertFiles(t, false) })
+	t.Run("WithSingleFile", func(t *testing.T) { testBasicNewClientCACertFiles(t, true) })
+
+	t.Run("Missing", func(t *testing.T) {
+		var (
+			_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+			basic     = Basic{
+				Address:           ":443",
+				CertificateFile:   []string{"cert.pem"},
+				KeyFile:           []string{"key.pem"},
+				ClientCACertFiles: []string{"client_ca.pem", "missing-file.pem"},
+			}
+		)
+
+		assert.Nil(t, basic.New(logger, nil))
+	})
+}
+
+func TestBasicListenAddress(t *testing.T) {
+	testData := []struct {
+		basic           Basic
+		expectedNetwork string
+		expectedAddress string
+	}{
+		{Basic{Address: ":8080"}, "", ":8080"},
+		{Basic{Network: "tcp4", Address: ":8080"}, "tcp4", ":8080"},
+		{Basic{Address: "unix:///var/run/test.sock"}, "unix", "/var/run/test.sock"},
+		{Basic{Network: "unixpacket", Address: "unix:///var/run/test.sock"}, "unixpacket", "/var/run/test.sock"},
+		{Basic{Network: "unix", Address: "/var/run/test.sock"}, "unix", "/var/run/test.sock"},
+	}
+
+	for i, record := range testData {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			network, address := record.basic.listenAddress()
+			assert.Equal(t, record.expectedNetwork, network)
+			assert.Equal(t, record.expectedAddress, address)
+		})
+	}
+}
+
+func TestBasicNewListenerUnix(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		socket = filepath.Join(t.TempDir(), "test.sock")
+		basic  = Basic{
+			Name:           "TestBasicNewListenerUnix",
+			Address:        "unix://" + socket,
+			MaxConnections: 1,
+		}
+
+		active   = generic.NewGauge("active")
+		rejected = generic.NewCounter("rejected")
+
+		requestActive float64
+	)
+
+	server := basic.New(logger, http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		requestActive = active.Value()
+		response.WriteHeader(http.StatusNoContent)
+	}))
+
+	require.NotNil(server)
+
+	listener, err := basic.NewListener(logger, active, rejected, nil)
+	require.NoError(err)
+	assert.Equal("unix", listener.Addr().Network())
+
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	response, err := client.Get("http://unix/")
+	require.NoError(err)
+	response.Body.Close()
+
+	assert.Equal(http.StatusNoContent, response.StatusCode)
+	assert.Equal(1.0, requestActive)
+	assert.Zero(rejected.Value())
+}
+
 func TestHealthNew(t *testing.T) {
 	const (
 		expectedName                      = "TestHealthNew"
@@ -389,6 +696,70 @@ func TestHealthNew(t *testing.T) {
 	}
 }
 
+func TestHealthNewProbes(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		h = Health{
+			Name:        "TestHealthNewProbes",
+			Address:     ":0",
+			LogInterval: time.Minute,
+		}
+
+		monitor, server = h.New(logger, alice.New(), nil)
+
+		serve = func(path string) int {
+			response := httptest.NewRecorder()
+			server.Handler.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+			return response.Code
+		}
+	)
+
+	require.NotNil(monitor)
+	require.NotNil(server)
+
+	assert.Equal(http.StatusOK, serve("/health"))
+	assert.Equal(http.StatusOK, serve("/live"))
+	assert.Equal(http.StatusOK, serve("/ready"))
+
+	monitor.SetReady(false)
+	assert.Equal(http.StatusOK, serve("/live"))
+	assert.Equal(http.StatusServiceUnavailable, serve("/ready"))
+
+	monitor.SetReady(true)
+	assert.Equal(http.StatusOK, serve("/live"))
+	assert.Equal(http.StatusOK, serve("/ready"))
+}
+
+func TestHealthNewStructuredJSON(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		h = Health{
+			Name:           "TestHealthNewStructuredJSON",
+			Address:        ":0",
+			LogInterval:    time.Minute,
+			StructuredJSON: true,
+		}
+
+		_, server = h.New(logger, alice.New(), nil)
+		response  = httptest.NewRecorder()
+	)
+
+	require.NotNil(server)
+	server.Handler.ServeHTTP(response, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(http.StatusOK, response.Code)
+
+	var report health.Report
+	require.NoError(json.Unmarshal(response.Body.Bytes(), &report))
+	assert.Equal(health.StatusReady, report.Status)
+	assert.Contains(report.Stats, health.TotalRequestsReceived)
+}
+
 func TestWebPANoPrimaryAddress(t *testing.T) {
 	var (
 		assert  = assert.New(t)
@@ -480,3 +851,116 @@ func TestWebPA(t *testing.T) {
 	waitGroup.Wait() // the http.Server instances will still be running after this returns
 	handler.AssertExpectations(t)
 }
+
+func testWebPAShutdownServer(t *testing.T, shutdownTimeout, handlerDuration time.Duration, expectSuccess bool) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		webPA = WebPA{ShutdownTimeout: shutdownTimeout}
+
+		handlerStarted = make(chan struct{})
+		server         = &http.Server{
+			Handler: http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+				close(handlerStarted)
+				time.Sleep(handlerDuration)
+				response.WriteHeader(http.StatusAccepted)
+			}),
+		}
+	)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	go server.Serve(listener)
+
+	type result struct {
+		response *http.Response
+		err      error
+	}
+
+	results := make(chan result, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String())
+		results <- result{response, err}
+	}()
+
+	select {
+	case <-handlerStarted:
+	case <-time.After(5 * time.Second):
+		require.Fail("the handler was not called")
+	}
+
+	webPA.shutdownServer(logger, server, listener, nil)
+
+	r := <-results
+	if expectSuccess {
+		require.NoError(r.err)
+		defer r.response.Body.Close()
+		assert.Equal(http.StatusAccepted, r.response.StatusCode)
+	} else {
+		assert.Error(r.err)
+	}
+}
+
+func testWebPAShutdownServerDrainsHijacked(t *testing.T) {
+	var (
+		assert    = assert.New(t)
+		require   = require.New(t)
+		_, logger = sallust.NewTestLogger(zapcore.DebugLevel)
+
+		webPA  = WebPA{ShutdownTimeout: 5 * time.Second}
+		active = generic.NewGauge("test")
+
+		hijacked = make(chan net.Conn, 1)
+		server   = &http.Server{
+			Handler: http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+				c, _, err := response.(http.Hijacker).Hijack()
+				if assert.NoError(err) {
+					hijacked <- c
+				}
+			}),
+		}
+	)
+
+	next, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	listener, err := xlistener.New(xlistener.Options{Logger: logger, Active: active, Next: next})
+	require.NoError(err)
+	go server.Serve(listener)
+
+	client, err := net.Dial("tcp", next.Addr().String())
+	require.NoError(err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(err)
+
+	var c net.Conn
+	select {
+	case c = <-hijacked:
+	case <-time.After(5 * time.Second):
+		require.Fail("the connection was not hijacked")
+	}
+
+	time.AfterFunc(100*time.Millisecond, func() { c.Close() })
+	assert.NoError(webPA.shutdownServer(logger, server, listener, nil))
+	assert.Zero(active.Value())
+}
+
+func TestWebPAShutdownServer(t *testing.T) {
+	t.Run("DrainsHijacked", testWebPAShutdownServerDrainsHijacked)
+
+	t.Run("InFlightCompletes", func(t *testing.T) {
+		testWebPAShutdownServer(t, 5*time.Second, 200*time.Millisecond, true)
+	})
+
+	t.Run("TimeoutCloses", func(t *testing.T) {
+		testWebPAShutdownServer(t, 100*time.Millisecond, 2*time.Second, false)
+	})
+
+	t.Run("NoTimeoutCloses", func(t *testing.T) {
+		testWebPAShutdownServer(t, 0, 2*time.Second, false)
+	})
+}
@@ -1,9 +1,16 @@
 package tracing
 
 import (
+	"context"
 	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName is the instrumentation name used for OpenTelemetry spans created by a Spanner
+const tracerName = "github.com/xmidt-org/webpa-common/v2/tracing"
+
 // Spanner acts as a factory for Spans
 type Spanner interface {
 	// Start begins a new, unfinished span.  The returned closure must be called
@@ -38,6 +45,17 @@ func Since(since func(time.Time) time.Duration) SpannerOption {
 	}
 }
 
+// WithTracerProvider bridges a spanner to OpenTelemetry.  Each span started by the spanner will
+// also start an OpenTelemetry span with the same name and start time, which is ended with the same
+// duration and error when the tracing span is finished.  If tp is nil, this option does nothing.
+func WithTracerProvider(tp trace.TracerProvider) SpannerOption {
+	return func(sp *spanner) {
+		if tp != nil {
+			sp.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
 // NewSpanner constructs a new Spanner with the given options.  By default, a Spanner
 // will use time.Now() to get the current time and time.Since() to compute durations.
 func NewSpanner(o ...SpannerOption) Spanner {
@@ -57,6 +75,9 @@ func NewSpanner(o ...SpannerOption) Spanner {
 type spanner struct {
 	now   func() time.Time
 	since func(time.Time) time.Duration
+
+	// tracer is the optional OpenTelemetry tracer.  If nil, no OpenTelemetry spans are created.
+	tracer trace.Tracer
 }
 
 func (sp *spanner) Start(name string) func(error) Span {
@@ -65,8 +86,24 @@ func (sp *spanner) Start(name string) func(error) Span {
 		start: sp.now(),
 	}
 
+	if sp.tracer == nil {
+		return func(err error) Span {
+			s.finish(sp.since(s.start), err)
+			return s
+		}
+	}
+
+	_, otelSpan := sp.tracer.Start(context.Background(), name, trace.WithTimestamp(s.start))
 	return func(err error) Span {
-		s.finish(sp.since(s.start), err)
+		if s.finish(sp.since(s.start), err) {
+			if err != nil {
+				otelSpan.RecordError(err)
+				otelSpan.SetStatus(codes.Error, err.Error())
+			}
+
+			otelSpan.End(trace.WithTimestamp(s.start.Add(s.duration)))
+		}
+
 		return s
 	}
 }
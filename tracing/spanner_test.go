@@ -9,6 +9,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func ExampleSpanner() {
@@ -92,3 +95,77 @@ func TestSpanner(t *testing.T) {
 	assert.Equal(expectedDuration, span.Duration())
 	assert.Equal(expectedError, span.Error())
 }
+
+func testSpannerTracerProviderSuccess(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		expectedStart    = time.Now()
+		expectedDuration = 150 * time.Millisecond
+
+		recorder = tracetest.NewSpanRecorder()
+		sp       = NewSpanner(
+			Now(func() time.Time { return expectedStart }),
+			Since(func(time.Time) time.Duration { return expectedDuration }),
+			WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		)
+	)
+
+	finisher := sp.Start("success")
+	assert.Empty(recorder.Ended())
+
+	finisher(nil)
+	finisher(errors.New("this should not get recorded"))
+
+	ended := recorder.Ended()
+	require.Len(ended, 1)
+	assert.Equal("success", ended[0].Name())
+	assert.Equal(expectedStart, ended[0].StartTime())
+	assert.Equal(expectedStart.Add(expectedDuration), ended[0].EndTime())
+	assert.Equal(codes.Unset, ended[0].Status().Code)
+	assert.Empty(ended[0].Events())
+}
+
+func testSpannerTracerProviderError(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		expectedError = errors.New("expected")
+
+		recorder = tracetest.NewSpanRecorder()
+		sp       = NewSpanner(
+			WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		)
+	)
+
+	span := sp.Start("failure")(expectedError)
+	require.NotNil(span)
+
+	ended := recorder.Ended()
+	require.Len(ended, 1)
+	assert.Equal("failure", ended[0].Name())
+	assert.Equal(span.Start().Add(span.Duration()), ended[0].EndTime())
+	assert.Equal(codes.Error, ended[0].Status().Code)
+	assert.Equal(expectedError.Error(), ended[0].Status().Description)
+	require.Len(ended[0].Events(), 1)
+	assert.Equal("exception", ended[0].Events()[0].Name)
+}
+
+func testSpannerTracerProviderNil(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		sp     = NewSpanner(WithTracerProvider(nil))
+	)
+
+	assert.Nil(sp.(*spanner).tracer)
+	span := sp.Start("test")(nil)
+	assert.Equal("test", span.Name())
+}
+
+func TestSpannerTracerProvider(t *testing.T) {
+	t.Run("Success", testSpannerTracerProviderSuccess)
+	t.Run("Error", testSpannerTracerProviderError)
+	t.Run("Nil", testSpannerTracerProviderNil)
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidWRPSpan indicates that a WRP span could not be parsed into a Span
+var ErrInvalidWRPSpan = errors.New("invalid WRP span")
+
+// ToWRPSpans converts spans into the representation carried by the Spans field of a WRP message.
+// Each span becomes a slice of its name, its start time in epoch milliseconds, and its duration in
+// milliseconds.  If a span has an error, the error text is appended as a fourth element.
+func ToWRPSpans(spans []Span) [][]string {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	wrpSpans := make([][]string, 0, len(spans))
+	for _, s := range spans {
+		wrpSpan := []string{
+			s.Name(),
+			strconv.FormatInt(s.Start().UnixMilli(), 10),
+			strconv.FormatInt(s.Duration().Milliseconds(), 10),
+		}
+
+		if err := s.Error(); err != nil {
+			wrpSpan = append(wrpSpan, err.Error())
+		}
+
+		wrpSpans = append(wrpSpans, wrpSpan)
+	}
+
+	return wrpSpans
+}
+
+// FromWRPSpans parses the WRP representation produced by ToWRPSpans back into spans.  Times are only
+// preserved to millisecond precision, and any error is reconstructed from its text.  If any WRP span is
+// malformed, an error wrapping ErrInvalidWRPSpan is returned.
+func FromWRPSpans(wrpSpans [][]string) ([]Span, error) {
+	if len(wrpSpans) == 0 {
+		return nil, nil
+	}
+
+	spans := make([]Span, 0, len(wrpSpans))
+	for i, wrpSpan := range wrpSpans {
+		if len(wrpSpan) < 3 || len(wrpSpan) > 4 {
+			return nil, fmt.Errorf("%w: span %d has %d elements", ErrInvalidWRPSpan, i, len(wrpSpan))
+		}
+
+		start, err := strconv.ParseInt(wrpSpan[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: span %d has an invalid start: %s", ErrInvalidWRPSpan, i, err)
+		}
+
+		duration, err := strconv.ParseInt(wrpSpan[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: span %d has an invalid duration: %s", ErrInvalidWRPSpan, i, err)
+		}
+
+		s := &span{
+			name:  wrpSpan[0],
+			start: time.UnixMilli(start),
+		}
+
+		var spanErr error
+		if len(wrpSpan) == 4 {
+			spanErr = errors.New(wrpSpan[3])
+		}
+
+		s.finish(time.Duration(duration)*time.Millisecond, spanErr)
+		spans = append(spans, s)
+	}
+
+	return spans, nil
+}
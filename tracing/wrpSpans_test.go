@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWRPSpansRoundTrip(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		start   = time.UnixMilli(1555555555555)
+		spanner = NewSpanner(
+			Now(func() time.Time { return start }),
+			Since(func(time.Time) time.Duration { return 1500 * time.Millisecond }),
+		)
+
+		spans = []Span{
+			spanner.Start("success")(nil),
+			spanner.Start("failure")(errors.New("expected")),
+		}
+	)
+
+	wrpSpans := ToWRPSpans(spans)
+	assert.Equal(
+		[][]string{
+			{"success", "1555555555555", "1500"},
+			{"failure", "1555555555555", "1500", "expected"},
+		},
+		wrpSpans,
+	)
+
+	actual, err := FromWRPSpans(wrpSpans)
+	require.NoError(err)
+	require.Len(actual, len(spans))
+	for i, expected := range spans {
+		assert.Equal(expected.Name(), actual[i].Name())
+		assert.True(expected.Start().Equal(actual[i].Start()))
+		assert.Equal(expected.Duration(), actual[i].Duration())
+	}
+
+	assert.NoError(actual[0].Error())
+	require.Error(actual[1].Error())
+	assert.Equal("expected", actual[1].Error().Error())
+}
+
+func testWRPSpansEmpty(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(ToWRPSpans(nil))
+
+	spans, err := FromWRPSpans(nil)
+	assert.Nil(spans)
+	assert.NoError(err)
+}
+
+func testWRPSpansInvalid(t *testing.T) {
+	for _, wrpSpan := range [][]string{
+		{},
+		{"name", "1"},
+		{"name", "1", "2", "error", "extra"},
+		{"name", "not a number", "2"},
+		{"name", "1", "not a number"},
+	} {
+		spans, err := FromWRPSpans([][]string{wrpSpan})
+		assert.Nil(t, spans)
+		assert.ErrorIs(t, err, ErrInvalidWRPSpan)
+	}
+}
+
+func TestWRPSpans(t *testing.T) {
+	t.Run("RoundTrip", testWRPSpansRoundTrip)
+	t.Run("Empty", testWRPSpansEmpty)
+	t.Run("Invalid", testWRPSpansInvalid)
+}
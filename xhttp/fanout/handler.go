@@ -4,10 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
+	"time"
 
+	"github.com/go-kit/kit/metrics/provider"
 	gokithttp "github.com/go-kit/kit/transport/http"
 	"github.com/xmidt-org/sallust"
 	"github.com/xmidt-org/webpa-common/v2/tracing"
@@ -18,6 +24,9 @@ import (
 var (
 	errNoFanoutURLs  = errors.New("No fanout URLs")
 	errBadTransactor = errors.New("Transactor did not conform to stdlib API")
+	errNoQuorum      = errors.New("Fanout responses did not reach a quorum")
+
+	errResponseBodyTooLarge = errors.New("Fanout response body exceeded the maximum size")
 )
 
 // Option provides a single configuration option for a fanout Handler
@@ -138,6 +147,98 @@ func WithConfiguration(c Configuration) Option {
 	}
 }
 
+// WithEndpointWeights configures weighted endpoint selection.  Rather than fanning out to every endpoint,
+// each request is sent to a single endpoint chosen at random in proportion to its weight.  Weights are keyed
+// by the host of each endpoint URL, e.g. "host.example.com:8080", and endpoints without a positive weight are
+// never chosen.  If weights is empty, or if none of a request's endpoints has a positive weight, the request
+// is sent to all endpoints.
+func WithEndpointWeights(weights map[string]int) Option {
+	return func(h *Handler) {
+		h.weights = make(map[string]int, len(weights))
+		for host, weight := range weights {
+			if weight > 0 {
+				h.weights[host] = weight
+			}
+		}
+	}
+}
+
+// WithQuorum configures the fanout to require agreement among endpoints.  When n is positive, the fanout
+// finishes once n responses share the same 2xx status code, and the configured ShouldTerminateFunc is not used.
+// If enough responses can no longer agree, the fanout fails.  A failed fanout whose worst response was not
+// an error is reported as http.StatusBadGateway.
+func WithQuorum(n int) Option {
+	return func(h *Handler) {
+		h.quorum = n
+	}
+}
+
+// WithEndpointTimeout configures timeouts for individual endpoints, keyed by the host of each endpoint URL.
+// A fanout request to an endpoint with a timeout is canceled once that timeout elapses, which produces a
+// failed Result without affecting the other fanout requests or the overall deadline.
+func WithEndpointTimeout(timeouts map[string]time.Duration) Option {
+	return func(h *Handler) {
+		h.timeouts = make(map[string]time.Duration, len(timeouts))
+		for host, timeout := range timeouts {
+			if timeout > 0 {
+				h.timeouts[host] = timeout
+			}
+		}
+	}
+}
+
+// WithHedge configures hedged fanout requests.  Rather than sending every fanout request at once, requests are
+// sent to one endpoint at a time, in order.  The next endpoint is tried once delay elapses without a terminating
+// response or as soon as every outstanding request has failed.  Outstanding requests are canceled once the fanout
+// finishes.  If delay is not positive, all fanout requests are sent at once.
+func WithHedge(delay time.Duration) Option {
+	return func(h *Handler) {
+		h.hedge = delay
+	}
+}
+
+// WithMetrics records a count of fanout responses, labeled by endpoint host and status class, along with a
+// histogram of fanout request latencies by endpoint.  See Metrics for the metric definitions.  When the Handler
+// uses FixedEndpoints, endpoint labels are bounded to those hosts.  If p is nil, no metrics are recorded.
+func WithMetrics(p provider.Provider) Option {
+	return func(h *Handler) {
+		if p != nil {
+			h.measures = newMeasures(p)
+		} else {
+			h.measures = nil
+		}
+	}
+}
+
+// WithMaxResponseBody bounds the size of each buffered fanout response body.  A response whose body exceeds
+// maxBytes is treated as a failed transaction with a http.StatusBadGateway status.  If maxBytes is not positive,
+// response bodies are not bounded.
+func WithMaxResponseBody(maxBytes int64) Option {
+	return func(h *Handler) {
+		h.maxResponseBody = maxBytes
+	}
+}
+
+// WithMaxConcurrency caps the number of fanout requests that are in flight at any one time for a single
+// fanout.  Once the cap is reached, each remaining endpoint is sent a request only as earlier requests complete
+// without terminating the fanout.  If n is not positive, which is the default, concurrency is not bounded.
+func WithMaxConcurrency(n int) Option {
+	return func(h *Handler) {
+		h.maxConcurrency = n
+	}
+}
+
+// WithStickyEndpoint configures sticky endpoint selection.  Rather than fanning out, each request is sent to a
+// single endpoint chosen by hashing the key that keyFunc returns for the original request, so that a given key
+// is consistently sent to the same endpoint.  If that endpoint's response does not terminate the fanout, the
+// remaining endpoints are tried one at a time in an order that is also determined by the key.  Requests for which
+// keyFunc returns an empty key are fanned out as usual.  Sticky selection takes precedence over endpoint weights.
+func WithStickyEndpoint(keyFunc func(*http.Request) string) Option {
+	return func(h *Handler) {
+		h.stickyKey = keyFunc
+	}
+}
+
 // Handler is the http.Handler that fans out HTTP requests using the configured Endpoints strategy.
 type Handler struct {
 	endpoints       Endpoints
@@ -147,6 +248,15 @@ type Handler struct {
 	failure         []FanoutResponseFunc
 	shouldTerminate ShouldTerminateFunc
 	transactor      func(*http.Request) (*http.Response, error)
+	weights         map[string]int
+	intn            func(int) int
+	quorum          int
+	timeouts        map[string]time.Duration
+	hedge           time.Duration
+	measures        *measures
+	maxResponseBody int64
+	maxConcurrency  int
+	stickyKey       func(*http.Request) string
 }
 
 // New creates a fanout Handler.  The Endpoints strategy is required, and this constructor function will
@@ -164,31 +274,99 @@ func New(e Endpoints, options ...Option) *Handler {
 		errorEncoder:    gokithttp.DefaultErrorEncoder,
 		shouldTerminate: DefaultShouldTerminate,
 		transactor:      http.DefaultClient.Do,
+		intn:            rand.Intn,
 	}
 
 	for _, o := range options {
 		o(h)
 	}
 
+	if fe, ok := e.(FixedEndpoints); ok && h.measures != nil {
+		h.measures.endpoints = make(map[string]bool, len(fe))
+		for _, u := range fe {
+			h.measures.endpoints[u.Host] = true
+		}
+	}
+
 	return h
 }
 
+// stickyEndpoints orders the fanout URLs for a sticky key using rendezvous hashing.  The first URL is the
+// endpoint the key is pinned to, and the rest are the endpoints to fail over to, in order.  Because each
+// endpoint is scored independently, adding or removing an endpoint only moves the keys pinned to it.
+func stickyEndpoints(key string, urls []*url.URL) []*url.URL {
+	scores := make(map[*url.URL]uint64, len(urls))
+	for _, u := range urls {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(key))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(u.Host))
+		scores[u] = hasher.Sum64()
+	}
+
+	ordered := append([]*url.URL(nil), urls...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return scores[ordered[i]] > scores[ordered[j]]
+	})
+
+	return ordered
+}
+
+// selectEndpoints applies any configured sticky selection or endpoint weights to the fanout URLs.  If a sticky
+// key applies, the URLs are returned in the order they should be tried and sequential is true.  If weights apply,
+// a single URL is chosen.  Otherwise, the URLs are returned as is.
+func (h *Handler) selectEndpoints(original *http.Request, urls []*url.URL) (selected []*url.URL, sequential bool) {
+	if h.stickyKey != nil {
+		if key := h.stickyKey(original); len(key) > 0 {
+			return stickyEndpoints(key, urls), true
+		}
+	}
+
+	if len(h.weights) == 0 {
+		return urls, false
+	}
+
+	total := 0
+	for _, u := range urls {
+		total += h.weights[u.Host]
+	}
+
+	if total == 0 {
+		return urls, false
+	}
+
+	n := h.intn(total)
+	for _, u := range urls {
+		weight := h.weights[u.Host]
+		if n < weight {
+			return []*url.URL{u}, false
+		}
+
+		n -= weight
+	}
+
+	return urls, false
+}
+
 // newFanoutRequests uses the Endpoints strategy and builds (1) HTTP request for each endpoint.  The configured
 // FanoutRequestFunc options are used to build each request.  This method returns an error if no endpoints were returned
-// by the strategy or if an error reading the original request body occurred.
-func (h *Handler) newFanoutRequests(fanoutCtx context.Context, original *http.Request) ([]*http.Request, error) {
+// by the strategy or if an error reading the original request body occurred.  The returned flag indicates whether
+// the requests must be sent one at a time, in order.
+func (h *Handler) newFanoutRequests(fanoutCtx context.Context, original *http.Request) ([]*http.Request, bool, error) {
 	body, err := ioutil.ReadAll(original.Body)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	urls, err := h.endpoints.FanoutURLs(original)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	} else if len(urls) == 0 {
-		return nil, errNoFanoutURLs
+		return nil, false, errNoFanoutURLs
 	}
 
+	urls, sequential := h.selectEndpoints(original, urls)
+
 	requests := make([]*http.Request, len(urls))
 	for i := 0; i < len(urls); i++ {
 		fanout := &http.Request{
@@ -206,20 +384,27 @@ func (h *Handler) newFanoutRequests(fanoutCtx context.Context, original *http.Re
 		for _, rf := range h.before {
 			endpointCtx, err = rf(endpointCtx, original, fanout, body)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 		}
 
 		requests[i] = fanout.WithContext(endpointCtx)
 	}
 
-	return requests, nil
+	return requests, sequential, nil
 }
 
 // execute performs a single fanout HTTP transaction and sends the result on a channel.  This method is invoked
 // as a goroutine.  It takes care of draining the fanout's response prior to returning.
 func (h *Handler) execute(logger *zap.Logger, spanner tracing.Spanner, results chan<- Result, request *http.Request) {
+	if timeout, ok := h.timeouts[request.URL.Host]; ok {
+		ctx, cancel := context.WithTimeout(request.Context(), timeout)
+		defer cancel()
+		request = request.WithContext(ctx)
+	}
+
 	var (
+		start    = time.Now()
 		finisher = spanner.Start(request.URL.String())
 		result   = Result{
 			Request: request,
@@ -232,8 +417,17 @@ func (h *Handler) execute(logger *zap.Logger, spanner tracing.Spanner, results c
 		result.StatusCode = result.Response.StatusCode
 		result.ContentType = result.Response.Header.Get("Content-Type")
 
-		var err error
-		if result.Body, err = ioutil.ReadAll(result.Response.Body); err != nil {
+		var (
+			err  error
+			body io.Reader = result.Response.Body
+		)
+
+		if h.maxResponseBody > 0 {
+			// read one extra byte to detect bodies that are too large
+			body = io.LimitReader(body, h.maxResponseBody+1)
+		}
+
+		if result.Body, err = ioutil.ReadAll(body); err != nil {
 			logger.Error("error reading fanout response body", zap.Error(err))
 		}
 
@@ -241,6 +435,18 @@ func (h *Handler) execute(logger *zap.Logger, spanner tracing.Spanner, results c
 			logger.Error("error closing fanout response body", zap.Error(err))
 		}
 
+		if h.maxResponseBody > 0 && int64(len(result.Body)) > h.maxResponseBody {
+			logger.Error("fanout response body too large", zap.Int64("maxResponseBody", h.maxResponseBody))
+			result.Response = nil
+			result.StatusCode = http.StatusBadGateway
+			result.Err = errResponseBodyTooLarge
+			result.Body = []byte(errResponseBodyTooLarge.Error())
+			result.ContentType = "text/plain"
+		} else {
+			// the buffered body replaces the original so that it can be read by callbacks
+			result.resetBody()
+		}
+
 	case result.Err != nil:
 		result.Body = []byte(fmt.Sprintf("%s", result.Err))
 		result.ContentType = "text/plain"
@@ -265,12 +471,17 @@ func (h *Handler) execute(logger *zap.Logger, spanner tracing.Spanner, results c
 	}
 
 	result.Span = finisher(result.Err)
+	if h.measures != nil {
+		h.measures.observe(result, time.Since(start).Seconds())
+	}
+
 	results <- result
 }
 
 // finish takes a terminating fanout result and writes the appropriate information to the top-level response.  This method
 // is only invoked when a particular fanout response terminates the fanout, i.e. is considered successful.
 func (h *Handler) finish(logger *zap.Logger, response http.ResponseWriter, result Result, after []FanoutResponseFunc) {
+	result.resetBody()
 	ctx := result.Request.Context()
 	for _, rf := range after {
 		// NOTE: we don't use the context for anything here,
@@ -298,11 +509,40 @@ func (h *Handler) finish(logger *zap.Logger, response http.ResponseWriter, resul
 	}
 }
 
+// noQuorumResult produces the result reported when a quorum cannot be reached.  An error result
+// is reported as is, while a non-error result is converted into a http.StatusBadGateway result.
+func noQuorumResult(r Result) Result {
+	if r.StatusCode >= 400 {
+		return r
+	}
+
+	r.StatusCode = http.StatusBadGateway
+	r.Err = errNoQuorum
+	r.Body = []byte(errNoQuorum.Error())
+	r.ContentType = "text/plain"
+	return r
+}
+
+// requestIndexKey is the context key for the position of a sequential request within its fanout
+type requestIndexKey struct{}
+
+// requestIndex returns the position of a sequential request within its fanout.  If the request
+// is not part of a sequential fanout, this function returns -1 and false.
+func requestIndex(request *http.Request) (int, bool) {
+	if request != nil {
+		if i, ok := request.Context().Value(requestIndexKey{}).(int); ok {
+			return i, true
+		}
+	}
+
+	return -1, false
+}
+
 func (h *Handler) ServeHTTP(response http.ResponseWriter, original *http.Request) {
 	var (
-		fanoutCtx     = original.Context()
-		logger        = sallust.Get(fanoutCtx)
-		requests, err = h.newFanoutRequests(fanoutCtx, original)
+		fanoutCtx             = original.Context()
+		logger                = sallust.Get(fanoutCtx)
+		requests, sticky, err = h.newFanoutRequests(fanoutCtx, original)
 	)
 
 	if err != nil {
@@ -316,20 +556,93 @@ func (h *Handler) ServeHTTP(response http.ResponseWriter, original *http.Request
 		results = make(chan Result, len(requests))
 	)
 
-	for _, r := range requests {
-		go h.execute(logger, spanner, results, r)
+	var (
+		dispatched = 0
+		received   = 0
+		dispatch   = func() {
+			go h.execute(logger, spanner, results, requests[dispatched])
+			dispatched++
+		}
+
+		// canDispatch indicates whether there are requests left to send that fit under any concurrency limit
+		canDispatch = func() bool {
+			return dispatched < len(requests) && (h.maxConcurrency <= 0 || dispatched-received < h.maxConcurrency)
+		}
+
+		hedge      <-chan time.Time
+		hedgeTimer *time.Timer
+
+		// cancels holds the cancellation function for each sequential request
+		cancels []context.CancelFunc
+	)
+
+	// hedged and sticky requests are sent one at a time
+	sequential := h.hedge > 0 || sticky
+	if sequential {
+		// each sequential request can be canceled individually once the fanout is done with it
+		cancels = make([]context.CancelFunc, len(requests))
+		for i, r := range requests {
+			ctx, cancel := context.WithCancel(context.WithValue(r.Context(), requestIndexKey{}, i))
+			defer cancel()
+			cancels[i] = cancel
+			requests[i] = r.WithContext(ctx)
+		}
+
+		dispatch()
+		if h.hedge > 0 && dispatched < len(requests) {
+			hedgeTimer = time.NewTimer(h.hedge)
+			defer hedgeTimer.Stop()
+			hedge = hedgeTimer.C
+		}
+	} else {
+		for canDispatch() {
+			dispatch()
+		}
+	}
+
+	// finish writes the chosen result.  Any other sequential requests still in flight are canceled
+	// first, so that they do not hold onto connections while the response is written.
+	finish := func(result Result, after []FanoutResponseFunc) {
+		winner, _ := requestIndex(result.Request)
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+
+		h.finish(logger, response, result, after)
 	}
 
-	statusCode := 0
-	var latestResponse Result
-	for i := 0; i < len(requests); i++ {
+	var (
+		statusCode     = 0
+		latestResponse Result
+
+		// agreed tracks the number of fanout responses with each 2xx status code, used for quorum
+		agreed    = make(map[int]int)
+		maxAgreed = 0
+	)
+
+	for received < len(requests) {
 		select {
 		case <-fanoutCtx.Done():
 			logger.Error("fanout operation canceled or timed out", zap.Int("statusCode", http.StatusGatewayTimeout), zap.Any("url", original.URL), zap.Error(fanoutCtx.Err()))
 			response.WriteHeader(http.StatusGatewayTimeout)
 			return
 
+		case <-hedge:
+			if canDispatch() {
+				logger.Debug("hedging fanout request", zap.Any("url", requests[dispatched].URL))
+				dispatch()
+			}
+
+			if dispatched < len(requests) {
+				hedgeTimer.Reset(h.hedge)
+			} else {
+				hedge = nil
+			}
+
 		case r := <-results:
+			received++
 			tracinghttp.HeadersForSpans("", response.Header(), r.Span)
 			if r.Err != nil {
 				logger.Error("fanout request complete", zap.Int("statusCode", r.StatusCode), zap.Any("url", r.Request.URL), zap.Error(r.Err))
@@ -337,9 +650,22 @@ func (h *Handler) ServeHTTP(response http.ResponseWriter, original *http.Request
 				logger.Debug("fanout request complete", zap.Int("statusCode", r.StatusCode), zap.Any("url", r.Request.URL))
 			}
 
-			if h.shouldTerminate(r) {
+			if h.quorum > 0 {
+				if r.StatusCode >= 200 && r.StatusCode < 300 {
+					agreed[r.StatusCode]++
+					if agreed[r.StatusCode] >= h.quorum {
+						// enough endpoints agree, so no reason to wait any longer
+						finish(r, h.after)
+						return
+					}
+
+					if maxAgreed < agreed[r.StatusCode] {
+						maxAgreed = agreed[r.StatusCode]
+					}
+				}
+			} else if r.resetBody(); h.shouldTerminate(r) {
 				// this was a "success", so no reason to wait any longer
-				h.finish(logger, response, r, h.after)
+				finish(r, h.after)
 				return
 			}
 
@@ -347,9 +673,39 @@ func (h *Handler) ServeHTTP(response http.ResponseWriter, original *http.Request
 				statusCode = r.StatusCode
 				latestResponse = r
 			}
+
+			if h.quorum > 0 && maxAgreed+len(requests)-received < h.quorum {
+				logger.Error("fanout quorum cannot be reached", zap.Int("quorum", h.quorum), zap.Int("maxAgreed", maxAgreed), zap.Any("url", original.URL))
+				finish(noQuorumResult(latestResponse), h.failure)
+				return
+			}
+
+			if !sequential {
+				// with a concurrency limit, a completed request makes room for the next one
+				for canDispatch() {
+					dispatch()
+				}
+			} else if received == dispatched && dispatched < len(requests) {
+				// every request so far has failed, so don't wait to try the next endpoint
+				dispatch()
+				if hedgeTimer != nil && dispatched < len(requests) {
+					// the timer may have fired while this result was handled, so drain any pending
+					// tick to avoid hedging the request that was just dispatched
+					if !hedgeTimer.Stop() {
+						select {
+						case <-hedgeTimer.C:
+						default:
+						}
+					}
+
+					hedgeTimer.Reset(h.hedge)
+				} else {
+					hedge = nil
+				}
+			}
 		}
 	}
 
 	logger.Error("all fanout requests failed", zap.Int("statusCode", statusCode), zap.Any("url", original.URL))
-	h.finish(logger, response, latestResponse, h.failure)
+	finish(latestResponse, h.failure)
 }
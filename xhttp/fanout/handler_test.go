@@ -7,7 +7,10 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +21,8 @@ import (
 	"github.com/xmidt-org/sallust"
 	"github.com/xmidt-org/webpa-common/v2/xhttp"
 	"github.com/xmidt-org/webpa-common/v2/xhttp/xhttptest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func testHandlerBodyError(t *testing.T) {
@@ -470,6 +475,92 @@ func TestHandler(t *testing.T) {
 		})
 	})
 
+	t.Run("EndpointWeights", func(t *testing.T) {
+		t.Run("None", func(t *testing.T) {
+			testHandlerEndpointWeights(t,
+				nil,
+				map[string]float64{
+					"host-0.webpa.net:8080": 1.0,
+					"host-1.webpa.net:8080": 1.0,
+					"host-2.webpa.net:8080": 1.0,
+					"host-3.webpa.net:8080": 1.0,
+				},
+			)
+		})
+
+		t.Run("Weighted", func(t *testing.T) {
+			testHandlerEndpointWeights(t,
+				map[string]int{
+					"host-0.webpa.net:8080": 70,
+					"host-1.webpa.net:8080": 20,
+					"host-2.webpa.net:8080": 10,
+				},
+				map[string]float64{
+					"host-0.webpa.net:8080": 0.7,
+					"host-1.webpa.net:8080": 0.2,
+					"host-2.webpa.net:8080": 0.1,
+				},
+			)
+		})
+	})
+
+	t.Run("Quorum", func(t *testing.T) {
+		testData := []struct {
+			quorum             int
+			statusCodes        []int
+			expectedStatusCode int
+			expectSuccess      bool
+		}{
+			{2, []int{200, 200, 500}, 200, true},
+			{2, []int{500, 204, 204}, 204, true},
+			{3, []int{200, 200, 500}, 500, false},
+			{2, []int{200, 204, 500}, 500, false},
+			{2, []int{200, 201, 204}, http.StatusBadGateway, false},
+			{3, []int{200, 500, 200, 503, 200}, 200, true},
+			{3, []int{202, 202, 202, 202, 202}, 202, true},
+			{3, []int{200, 200, 500, 503, 504}, 504, false},
+			{3, []int{200, 204, 204, 200, 404}, 404, false},
+			{6, []int{200, 200, 200, 200, 200}, http.StatusBadGateway, false},
+		}
+
+		for i, record := range testData {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				testHandlerQuorum(t, record.quorum, record.statusCodes, record.expectedStatusCode, record.expectSuccess)
+			})
+		}
+	})
+
+	t.Run("EndpointTimeout", func(t *testing.T) {
+		t.Run("FastSuccess", func(t *testing.T) {
+			testHandlerEndpointTimeout(t, http.StatusOK, http.StatusOK)
+		})
+
+		t.Run("FastFailure", func(t *testing.T) {
+			testHandlerEndpointTimeout(t, http.StatusNotFound, http.StatusGatewayTimeout)
+		})
+	})
+
+	t.Run("Hedge", func(t *testing.T) {
+		t.Run("Stalled", testHandlerHedgeStalled)
+		t.Run("NotNeeded", testHandlerHedgeNotNeeded)
+		t.Run("FailedAfterDelay", testHandlerHedgeFailedAfterDelay)
+		t.Run("AllFailed", testHandlerHedgeAllFailed)
+	})
+
+	t.Run("MaxConcurrency", func(t *testing.T) {
+		t.Run("Bounded", testHandlerMaxConcurrencyBounded)
+		t.Run("Terminates", testHandlerMaxConcurrencyTerminates)
+	})
+
+	t.Run("StickyEndpoint", func(t *testing.T) {
+		t.Run("Consistent", testHandlerStickyEndpointConsistent)
+		t.Run("Failover", testHandlerStickyEndpointFailover)
+		t.Run("NoKey", testHandlerStickyEndpointNoKey)
+	})
+
+	t.Run("ShouldTerminateBody", testHandlerShouldTerminateBody)
+	t.Run("MaxResponseBody", testHandlerMaxResponseBody)
+
 	t.Run("Timeout", func(t *testing.T) {
 		for _, endpointCount := range []int{1, 2, 3, 5} {
 			t.Run(fmt.Sprintf("EndpointCount=%d", endpointCount), func(t *testing.T) {
@@ -479,6 +570,591 @@ func TestHandler(t *testing.T) {
 	})
 }
 
+func testHandlerEndpointWeights(t *testing.T, weights map[string]int, expected map[string]float64) {
+	const requestCount = 10000
+
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(4)
+		counts    = make(map[string]int)
+		lock      sync.Mutex
+
+		handler = New(endpoints,
+			WithEndpointWeights(weights),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				lock.Lock()
+				counts[request.URL.Host]++
+				lock.Unlock()
+
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       http.NoBody,
+				}, nil
+			}),
+		)
+	)
+
+	require.NotNil(handler)
+	for i := 0; i < requestCount; i++ {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+		require.Equal(http.StatusOK, response.Code)
+	}
+
+	// allow a little time for any remaining transactions to complete
+	require.Eventually(
+		func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+
+			total := 0
+			for _, count := range counts {
+				total += count
+			}
+
+			expectedTotal := requestCount
+			if len(weights) == 0 {
+				expectedTotal *= len(endpoints)
+			}
+
+			return total == expectedTotal
+		},
+		5*time.Second,
+		10*time.Millisecond,
+	)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, e := range endpoints {
+		assert.InDelta(expected[e.Host], float64(counts[e.Host])/requestCount, 0.03, "host %s", e.Host)
+	}
+}
+
+func testHandlerQuorum(t *testing.T, quorum int, statusCodeList []int, expectedStatusCode int, expectSuccess bool) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints   = generateEndpoints(len(statusCodeList))
+		statusCodes = make(map[string]int, len(statusCodeList))
+
+		afterCalled   = false
+		failureCalled = false
+
+		handler = New(endpoints,
+			WithQuorum(quorum),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				statusCode := statusCodes[request.URL.Host]
+				return &http.Response{
+					StatusCode: statusCode,
+					Header:     http.Header{"Content-Type": {"text/plain"}},
+					Body:       io.NopCloser(strings.NewReader(strconv.Itoa(statusCode))),
+				}, nil
+			}),
+			WithFanoutAfter(func(ctx context.Context, _ http.ResponseWriter, _ Result) context.Context {
+				afterCalled = true
+				return ctx
+			}),
+			WithFanoutFailure(func(ctx context.Context, _ http.ResponseWriter, _ Result) context.Context {
+				failureCalled = true
+				return ctx
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	for i, e := range endpoints {
+		statusCodes[e.Host] = statusCodeList[i]
+	}
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(expectedStatusCode, response.Code)
+	assert.Equal(expectSuccess, afterCalled)
+	assert.Equal(!expectSuccess, failureCalled)
+	if expectSuccess {
+		assert.Equal(strconv.Itoa(expectedStatusCode), response.Body.String())
+	}
+}
+
+func testHandlerEndpointTimeout(t *testing.T, fastStatusCode int, expectedStatusCode int) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(2)
+		slowHost  = endpoints[0].Host
+
+		slowResult = make(chan error, 1)
+
+		handler = New(endpoints,
+			WithEndpointTimeout(map[string]time.Duration{
+				slowHost: 50 * time.Millisecond,
+			}),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				if request.URL.Host == slowHost {
+					select {
+					case <-request.Context().Done():
+						slowResult <- request.Context().Err()
+						return nil, request.Context().Err()
+					case <-time.After(5 * time.Second):
+						slowResult <- nil
+						return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+					}
+				}
+
+				// the fast endpoint responds after the slow endpoint's timeout
+				time.Sleep(200 * time.Millisecond)
+				return &http.Response{StatusCode: fastStatusCode, Body: http.NoBody}, nil
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(expectedStatusCode, response.Code)
+
+	select {
+	case err := <-slowResult:
+		assert.Equal(context.DeadlineExceeded, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("the slow endpoint was not called")
+	}
+}
+
+func testHandlerHedgeStalled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints  = generateEndpoints(2)
+		canceled   = make(chan struct{})
+		stalledCtx = make(chan context.Context, 1)
+
+		afterCalled   = false
+		failureCalled = false
+
+		handler = New(endpoints,
+			WithHedge(50*time.Millisecond),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				if request.URL.Host == endpoints[0].Host {
+					// the first endpoint stalls until the hedged request wins
+					stalledCtx <- request.Context()
+					<-request.Context().Done()
+					close(canceled)
+					return nil, request.Context().Err()
+				}
+
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": {"text/plain"}},
+					Body:       io.NopCloser(strings.NewReader("hedged")),
+				}, nil
+			}),
+			WithFanoutAfter(func(ctx context.Context, _ http.ResponseWriter, result Result) context.Context {
+				afterCalled = true
+				assert.Equal(endpoints[1].Host, result.Request.URL.Host)
+				assert.NoError(result.Request.Context().Err(), "the winning request should not be canceled")
+
+				// the stalled request is canceled as soon as the winner is chosen, not when ServeHTTP returns
+				assert.Equal(context.Canceled, (<-stalledCtx).Err())
+				return ctx
+			}),
+			WithFanoutFailure(func(ctx context.Context, _ http.ResponseWriter, _ Result) context.Context {
+				failureCalled = true
+				return ctx
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("hedged", response.Body.String())
+	assert.True(afterCalled)
+	assert.False(failureCalled)
+
+	select {
+	case <-canceled:
+		// passing
+	case <-time.After(5 * time.Second):
+		assert.Fail("the stalled request was not canceled")
+	}
+}
+
+func testHandlerHedgeFailedAfterDelay(t *testing.T) {
+	const hedgeDelay = 50 * time.Millisecond
+
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(3)
+		calls     = make(chan string, len(endpoints))
+
+		// handling the first endpoint's failure outlasts the hedge delay, so the hedge
+		// timer fires before the next endpoint is dispatched
+		logger = sallust.Default().WithOptions(zap.Hooks(func(entry zapcore.Entry) error {
+			if entry.Level == zapcore.ErrorLevel && entry.Message == "fanout request complete" {
+				time.Sleep(2 * hedgeDelay)
+			}
+
+			return nil
+		}))
+
+		handler = New(endpoints,
+			WithHedge(hedgeDelay),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				calls <- request.URL.Host
+				if request.URL.Host == endpoints[0].Host {
+					return nil, errors.New("expected")
+				}
+
+				// well within the hedge delay, so no further hedge is needed
+				time.Sleep(hedgeDelay / 5)
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}),
+		)
+
+		response = httptest.NewRecorder()
+		request  = httptest.NewRequest("GET", "/api/v2/something", nil)
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, request.WithContext(sallust.With(request.Context(), logger)))
+	assert.Equal(http.StatusOK, response.Code)
+
+	close(calls)
+	var hosts []string
+	for host := range calls {
+		hosts = append(hosts, host)
+	}
+
+	assert.Equal([]string{endpoints[0].Host, endpoints[1].Host}, hosts, "a stale hedge tick should not dispatch another request")
+}
+
+func testHandlerHedgeNotNeeded(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(2)
+		calls     int32
+
+		handler = New(endpoints,
+			WithHedge(time.Minute),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&calls, 1)
+				assert.Equal(endpoints[0].Host, request.URL.Host)
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+}
+
+func testHandlerHedgeAllFailed(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(3)
+		calls     int32
+
+		afterCalled   = false
+		failureCalled = false
+
+		handler = New(endpoints,
+			// failures should advance to the next endpoint without waiting for the hedge delay
+			WithHedge(time.Minute),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&calls, 1)
+				return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil
+			}),
+			WithFanoutAfter(func(ctx context.Context, _ http.ResponseWriter, _ Result) context.Context {
+				afterCalled = true
+				return ctx
+			}),
+			WithFanoutFailure(func(ctx context.Context, _ http.ResponseWriter, _ Result) context.Context {
+				failureCalled = true
+				return ctx
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(http.StatusInternalServerError, response.Code)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+	assert.False(afterCalled)
+	assert.True(failureCalled)
+}
+
+func testHandlerMaxConcurrencyBounded(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(20)
+		calls     int32
+		inFlight  int32
+		maxSeen   int32
+
+		handler = New(endpoints,
+			WithMaxConcurrency(3),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&calls, 1)
+				current := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+
+				for {
+					seen := atomic.LoadInt32(&maxSeen)
+					if current <= seen || atomic.CompareAndSwapInt32(&maxSeen, seen, current) {
+						break
+					}
+				}
+
+				time.Sleep(5 * time.Millisecond)
+				return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(http.StatusInternalServerError, response.Code)
+	assert.Equal(int32(20), atomic.LoadInt32(&calls))
+	assert.LessOrEqual(atomic.LoadInt32(&maxSeen), int32(3))
+	assert.Greater(atomic.LoadInt32(&maxSeen), int32(0))
+}
+
+func testHandlerMaxConcurrencyTerminates(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(5)
+		calls     int32
+
+		handler = New(endpoints,
+			WithMaxConcurrency(1),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&calls, 1)
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+}
+
+func deviceKey(request *http.Request) string {
+	return request.Header.Get("X-Device")
+}
+
+func testHandlerStickyEndpointConsistent(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(5)
+		lock      sync.Mutex
+		hosts     []string
+
+		handler = New(endpoints,
+			WithStickyEndpoint(deviceKey),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				lock.Lock()
+				hosts = append(hosts, request.URL.Host)
+				lock.Unlock()
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}),
+		)
+	)
+
+	require.NotNil(handler)
+	for _, key := range []string{"mac:112233445566", "mac:665544332211", "uuid:1234"} {
+		hosts = nil
+		for i := 0; i < 5; i++ {
+			request := httptest.NewRequest("GET", "/api/v2/something", nil)
+			request.Header.Set("X-Device", key)
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			assert.Equal(http.StatusOK, response.Code)
+		}
+
+		require.Len(hosts, 5)
+		for _, host := range hosts {
+			assert.Equal(hosts[0], host, "key %s was not sticky", key)
+		}
+	}
+}
+
+func testHandlerStickyEndpointFailover(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(5)
+		key       = "mac:112233445566"
+		ordered   = stickyEndpoints(key, endpoints)
+		lock      sync.Mutex
+		hosts     []string
+
+		handler = New(endpoints,
+			WithStickyEndpoint(deviceKey),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				lock.Lock()
+				hosts = append(hosts, request.URL.Host)
+				lock.Unlock()
+
+				if request.URL.Host == ordered[0].Host {
+					return nil, errors.New("expected")
+				}
+
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}),
+		)
+
+		request  = httptest.NewRequest("GET", "/api/v2/something", nil)
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	request.Header.Set("X-Device", key)
+	handler.ServeHTTP(response, request)
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal([]string{ordered[0].Host, ordered[1].Host}, hosts)
+}
+
+func testHandlerStickyEndpointNoKey(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(3)
+		calls     int32
+
+		handler = New(endpoints,
+			WithStickyEndpoint(deviceKey),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&calls, 1)
+				return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(http.StatusInternalServerError, response.Code)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+}
+
+func testHandlerShouldTerminateBody(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		endpoints = generateEndpoints(2)
+		bodies    = map[string]string{
+			endpoints[0].Host: `{"error": "something went wrong"}`,
+			endpoints[1].Host: `{"value": "clean"}`,
+		}
+
+		rejected = make(chan struct{}, 1)
+
+		handler = New(endpoints,
+			WithMaxResponseBody(1024),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				if request.URL.Host == endpoints[1].Host {
+					// ensure the error envelope is seen first
+					<-rejected
+				}
+
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": {"application/json"}},
+					Body:       io.NopCloser(strings.NewReader(bodies[request.URL.Host])),
+				}, nil
+			}),
+			WithShouldTerminate(func(result Result) bool {
+				body, err := io.ReadAll(result.Response.Body)
+				assert.NoError(err)
+				if strings.Contains(string(body), "error") {
+					rejected <- struct{}{}
+					return false
+				}
+
+				return DefaultShouldTerminate(result)
+			}),
+			WithClientAfter(func(ctx context.Context, response *http.Response) context.Context {
+				// the body can still be read after the termination predicate has read it
+				body, err := io.ReadAll(response.Body)
+				assert.NoError(err)
+				assert.Equal(bodies[endpoints[1].Host], string(body))
+				return ctx
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(http.StatusOK, response.Code)
+	assert.Equal("application/json", response.Header().Get("Content-Type"))
+	assert.Equal(bodies[endpoints[1].Host], response.Body.String())
+}
+
+func testHandlerMaxResponseBody(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		handler = New(generateEndpoints(1),
+			WithMaxResponseBody(4),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("too large")),
+				}, nil
+			}),
+		)
+
+		response = httptest.NewRecorder()
+	)
+
+	require.NotNil(handler)
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/v2/something", nil))
+	assert.Equal(http.StatusBadGateway, response.Code)
+	assert.Equal(errResponseBodyTooLarge.Error(), response.Body.String())
+}
+
 func testNewNilEndpoints(t *testing.T) {
 	assert := assert.New(t)
 	assert.Panics(func() {
@@ -0,0 +1,75 @@
+package fanout
+
+import (
+	"strconv"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/provider"
+
+	// nolint:staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics"
+)
+
+const (
+	ResponseCounter         = "fanout_response_count"
+	RequestDurationSeconds  = "fanout_request_duration_seconds"
+	EndpointLabel           = "endpoint"
+	StatusClassLabel        = "status_class"
+	OtherEndpointLabelValue = "other"
+)
+
+// Metrics is the fanout module function for metrics
+func Metrics() []xmetrics.Metric {
+	return []xmetrics.Metric{
+		{
+			Name:       ResponseCounter,
+			Type:       "counter",
+			Help:       "The total count of fanout responses by endpoint and status class",
+			LabelNames: []string{EndpointLabel, StatusClassLabel},
+		},
+		{
+			Name:       RequestDurationSeconds,
+			Type:       "histogram",
+			Help:       "A histogram of fanout request latencies by endpoint",
+			LabelNames: []string{EndpointLabel},
+			Buckets:    []float64{0.0625, 0.125, .25, .5, 1, 5, 10, 20, 40, 80, 160},
+		},
+	}
+}
+
+// measures holds the per-endpoint fanout metrics
+type measures struct {
+	responses metrics.Counter
+	duration  metrics.Histogram
+
+	// endpoints is the bounded set of endpoint label values.  If nil, every endpoint host is used as is.
+	endpoints map[string]bool
+}
+
+func newMeasures(p provider.Provider) *measures {
+	return &measures{
+		responses: p.NewCounter(ResponseCounter),
+		duration:  p.NewHistogram(RequestDurationSeconds, 11),
+	}
+}
+
+// endpoint returns the endpoint label value for a host
+func (m *measures) endpoint(host string) string {
+	if m.endpoints == nil || m.endpoints[host] {
+		return host
+	}
+
+	return OtherEndpointLabelValue
+}
+
+// statusClass returns the status class label value for a status code, e.g. "2xx"
+func statusClass(statusCode int) string {
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// observe records a single fanout result
+func (m *measures) observe(r Result, seconds float64) {
+	endpoint := m.endpoint(r.Request.URL.Host)
+	m.responses.With(EndpointLabel, endpoint, StatusClassLabel, statusClass(r.StatusCode)).Add(1.0)
+	m.duration.With(EndpointLabel, endpoint).Observe(seconds)
+}
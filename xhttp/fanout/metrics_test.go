@@ -0,0 +1,76 @@
+package fanout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest"
+)
+
+func TestMetrics(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		p = xmetricstest.NewProvider(nil, Metrics)
+
+		endpoints   = generateEndpoints(3)
+		statusCodes = map[string]int{
+			endpoints[0].Host: http.StatusOK,
+			endpoints[1].Host: http.StatusInternalServerError,
+			endpoints[2].Host: http.StatusNotFound,
+		}
+
+		handler = New(endpoints,
+			WithMetrics(p),
+			// wait for every endpoint, so that all metrics are recorded before ServeHTTP returns
+			WithShouldTerminate(func(Result) bool { return false }),
+			WithTransactor(func(request *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: statusCodes[request.URL.Host], Body: http.NoBody}, nil
+			}),
+		)
+	)
+
+	require.NotNil(handler)
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v2/something", nil))
+	}
+
+	p.Assert(t, ResponseCounter, EndpointLabel, endpoints[0].Host, StatusClassLabel, "2xx")(xmetricstest.Value(3.0))
+	p.Assert(t, ResponseCounter, EndpointLabel, endpoints[1].Host, StatusClassLabel, "5xx")(xmetricstest.Value(3.0))
+	p.Assert(t, ResponseCounter, EndpointLabel, endpoints[2].Host, StatusClassLabel, "4xx")(xmetricstest.Value(3.0))
+	p.Assert(t, ResponseCounter, EndpointLabel, endpoints[0].Host, StatusClassLabel, "5xx")(xmetricstest.Value(0.0))
+
+	for _, e := range endpoints {
+		p.Assert(t, RequestDurationSeconds, EndpointLabel, e.Host)(xmetricstest.Histogram)
+	}
+
+	assert.Equal(map[string]bool{
+		endpoints[0].Host: true,
+		endpoints[1].Host: true,
+		endpoints[2].Host: true,
+	}, handler.measures.endpoints)
+}
+
+func TestMeasuresEndpoint(t *testing.T) {
+	var (
+		bounded   = &measures{endpoints: map[string]bool{"known.webpa.net": true}}
+		unbounded = &measures{}
+	)
+
+	assert.Equal(t, "known.webpa.net", bounded.endpoint("known.webpa.net"))
+	assert.Equal(t, OtherEndpointLabelValue, bounded.endpoint("unknown.webpa.net"))
+	assert.Equal(t, "unknown.webpa.net", unbounded.endpoint("unknown.webpa.net"))
+}
+
+func TestStatusClass(t *testing.T) {
+	for statusCode, expected := range map[int]string{200: "2xx", 204: "2xx", 302: "3xx", 404: "4xx", 503: "5xx"} {
+		t.Run(strconv.Itoa(statusCode), func(t *testing.T) {
+			assert.Equal(t, expected, statusClass(statusCode))
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package fanout
 
 import (
+	"bytes"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/xmidt-org/webpa-common/v2/tracing"
@@ -16,6 +18,7 @@ type Result struct {
 	Request *http.Request
 
 	// Response is the HTTP response returned by the fanout HTTP transaction.  If set, Err will be nil.
+	// The response's Body is buffered, and reading it yields the same content as the Body field.
 	Response *http.Response
 
 	// Err is the error returned by the fanout HTTP transaction.  If set, Response will be nil.
@@ -31,6 +34,14 @@ type Result struct {
 	Span tracing.Span
 }
 
+// resetBody replaces the Response's Body, if any, with a fresh reader over the buffered Body.
+// This allows each callback to read the response body from the beginning.
+func (r Result) resetBody() {
+	if r.Response != nil {
+		r.Response.Body = ioutil.NopCloser(bytes.NewReader(r.Body))
+	}
+}
+
 // ShouldTerminateFunc is a predicate for determining if a fanout should terminate early given the results of
 // a single HTTP transaction.
 type ShouldTerminateFunc func(Result) bool
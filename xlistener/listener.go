@@ -1,26 +1,54 @@
 package xlistener
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 
+	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/discard"
 	"github.com/xmidt-org/sallust"
 	"github.com/xmidt-org/webpa-common/v2/xmetrics"
 	"go.uber.org/zap"
 )
 
+const (
+	// RejectedReasonLabel is the label used to record why a connection was rejected, when the
+	// Rejected metric supports labels.
+	RejectedReasonLabel = "reason"
+
+	// MaxConnectionsReason indicates a connection was rejected because MaxConnections was reached.
+	MaxConnectionsReason = "max_connections"
+
+	// AcceptErrorReason indicates the decorated net.Listener returned an error from Accept.
+	AcceptErrorReason = "accept_error"
+
+	// TLSHandshakeReason indicates a TLS connection failed before its handshake completed.
+	TLSHandshakeReason = "tls_handshake"
+
+	// UnknownReason is used for rejections that do not supply a reason.
+	UnknownReason = "unknown"
+)
+
 var (
 	// netListen is the factory function for creating a net.Listener.  Defaults to net.Listen.  Only tests would change this variable.
 	netListen = net.Listen
-
-	// tlsListen is the factory function for creating a tls.Listener.  Defaults to tls.Listen.  Only tests would change this variable.
-	tlsListen = tls.Listen
 )
 
+// Drainer is implemented by listeners that can stop accepting connections and wait for
+// the connections they have already accepted to close.  Listeners returned by New implement this interface.
+type Drainer interface {
+	// Drain closes the listener so that no new connections are accepted, then blocks until all
+	// connections previously accepted through the listener are closed or the context is done.
+	// If the context is done first, its error is returned.
+	Drain(context.Context) error
+}
+
 // Options defines the available options for configuring a listener
 type Options struct {
 	// Logger is the go-kit logger to use for output.  If unset, sallust.Default() is used.
@@ -33,6 +61,10 @@ type Options struct {
 	// Rejected is is incremented each time the listener rejects a connection.  If unset, a go-kit discard Counter is used.
 	Rejected xmetrics.Adder
 
+	// LabelRejected causes each increment of Rejected to be labeled with RejectedReasonLabel, when Rejected is
+	// a go-kit metrics.Counter.  The counter must declare that label.  By default, Rejected is not labeled.
+	LabelRejected bool
+
 	// Active is updated to reflect the current number of active connections.  If unset, a go-kit discard Gauge is used.
 	Active xmetrics.Adder
 
@@ -45,6 +77,8 @@ type Options struct {
 	// Next is the net.Listener to decorate.  If this field is set, Network and Address are ignored.
 	Next net.Listener
 
+	// Config, if set, causes each accepted connection to be served over TLS.  Accepted connections
+	// are returned as *tls.Conn, so that an http.Server can negotiate protocols such as HTTP/2 through TLSNextProto.
 	Config *tls.Config
 }
 
@@ -85,11 +119,7 @@ func New(o Options) (net.Listener, error) {
 		}
 
 		var err error
-		if o.Config != nil {
-			next, err = tlsListen(o.Network, o.Address, o.Config)
-		} else {
-			next, err = netListen(o.Network, o.Address)
-		}
+		next, err = netListen(o.Network, o.Address)
 		if err != nil {
 			return nil, err
 		}
@@ -99,18 +129,54 @@ func New(o Options) (net.Listener, error) {
 		Listener:  next,
 		logger:    o.Logger.With(zap.String("listenNetwork", next.Addr().Network()), zap.String("listenAddress", next.Addr().String())),
 		semaphore: semaphore,
-		rejected:  xmetrics.NewIncrementer(o.Rejected),
+		rejected:  newRejecter(o.Rejected, o.LabelRejected),
 		active:    o.Active,
+		config:    o.Config,
 	}, nil
 }
 
+// rejecter counts rejected connections, labeling each rejection with its reason if possible
+type rejecter func(reason string)
+
+// newRejecter produces a rejecter for the given metric.  If labeled is true and the metric is a go-kit
+// metrics.Counter, it is labeled with the reason.  Otherwise, the metric is simply incremented.
+func newRejecter(a xmetrics.Adder, labeled bool) rejecter {
+	if c, ok := a.(metrics.Counter); ok && labeled {
+		return func(reason string) {
+			if len(reason) == 0 {
+				reason = UnknownReason
+			}
+
+			c.With(RejectedReasonLabel, reason).Add(1.0)
+		}
+	}
+
+	i := xmetrics.NewIncrementer(a)
+	return func(string) {
+		i.Inc()
+	}
+}
+
+// Inc increments the rejected connections metric with UnknownReason
+func (r rejecter) Inc() {
+	r(UnknownReason)
+}
+
 // listener decorates a net.Listener with metrics and optional maximum connection enforcement
 type listener struct {
 	net.Listener
 	logger    *zap.Logger
 	semaphore chan struct{}
-	rejected  xmetrics.Incrementer
+	rejected  rejecter
 	active    xmetrics.Adder
+	config    *tls.Config
+
+	closeOnce sync.Once
+	closeErr  error
+
+	lock  sync.Mutex
+	count int
+	idle  chan struct{}
 }
 
 // acquire attempts to obtain a semaphore resource.  If the semaphore has not been set (i.e. no maximum connections),
@@ -118,13 +184,13 @@ type listener struct {
 // In all cases, the active connections gauge is updated if appropriate.
 func (l *listener) acquire() bool {
 	if l.semaphore == nil {
-		l.active.Add(1.0)
+		l.track(1)
 		return true
 	}
 
 	select {
 	case l.semaphore <- struct{}{}:
-		l.active.Add(1.0)
+		l.track(1)
 		return true
 	default:
 		return false
@@ -133,12 +199,62 @@ func (l *listener) acquire() bool {
 
 // release returns a semaphore resource to the pool, if set.  This method also decrements the active connection gauge.
 func (l *listener) release() {
-	l.active.Add(-1.0)
+	l.track(-1)
 	if l.semaphore != nil {
 		<-l.semaphore
 	}
 }
 
+// track adjusts the count of open connections along with the active connections gauge.  If a drain
+// is waiting and the count reaches zero, the drain is signaled.
+func (l *listener) track(delta int) {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+
+	l.active.Add(float64(delta))
+	l.count += delta
+	if l.count == 0 && l.idle != nil {
+		close(l.idle)
+		l.idle = nil
+	}
+}
+
+// Close closes the decorated net.Listener.  This method is idempotent, and subsequent calls return
+// the result of the first call.
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.closeErr = l.Listener.Close()
+	})
+
+	return l.closeErr
+}
+
+// Drain closes this listener, then waits for all of its open connections to be closed or for the
+// context to be done, whichever happens first.
+func (l *listener) Drain(ctx context.Context) error {
+	l.Close()
+
+	l.lock.Lock()
+	if l.count == 0 {
+		l.lock.Unlock()
+		return nil
+	}
+
+	if l.idle == nil {
+		l.idle = make(chan struct{})
+	}
+
+	idle := l.idle
+	l.lock.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Accept invokes the delegate net.Listener's Accept method, then attempts to acquire the semaphore.
 // If the semaphore was set and could not be acquired, the accepted connection is immediately closed.
 func (l *listener) Accept() (net.Conn, error) {
@@ -151,6 +267,10 @@ func (l *listener) Accept() (net.Conn, error) {
 			}
 
 			l.logger.Error("failed to accept connection", zap.Error(err), zap.String("sysValue", sysValue))
+			if !errors.Is(err, net.ErrClosed) {
+				l.rejected(AcceptErrorReason)
+			}
+
 			if err == syscall.ENFILE {
 				l.logger.Error("ENFILE received.  translating to EMFILE")
 				return nil, syscall.EMFILE
@@ -161,26 +281,120 @@ func (l *listener) Accept() (net.Conn, error) {
 
 		if !l.acquire() {
 			l.logger.Error("rejected connection", zap.String("remoteAddress", c.RemoteAddr().String()))
-			l.rejected.Inc()
+			l.rejected(MaxConnectionsReason)
 			c.Close()
 			continue
 		}
 
 		l.logger.Debug("accepted connection", zap.String("remoteAddress", c.RemoteAddr().String()))
-		return &conn{Conn: c, release: l.release}, nil
+		decorated := &conn{Conn: c, release: l.release}
+		if l.config != nil {
+			// TLS is layered over the decorated connection, so that callers see a *tls.Conn
+			decorated.rejected = l.rejected
+			decorated.handshaking = decorated.awaitingVerification
+			decorated.layered = true
+			return tls.Server(decorated, l.serverConfig(decorated)), nil
+		}
+
+		if tc, ok := c.(*tls.Conn); ok {
+			decorated.rejected = l.rejected
+			decorated.handshaking = func() bool {
+				return !tc.ConnectionState().HandshakeComplete
+			}
+		}
+
+		return decorated, nil
 	}
 }
 
+// serverConfig produces the TLS configuration for a single accepted connection.  The
+// configuration marks the connection as verified once its handshake succeeds.
+func (l *listener) serverConfig(c *conn) *tls.Config {
+	config := l.config.Clone()
+	config.VerifyConnection = c.verifyConnection(config.VerifyConnection)
+	if getConfigForClient := config.GetConfigForClient; getConfigForClient != nil {
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			clientConfig, err := getConfigForClient(hello)
+			if clientConfig != nil {
+				clientConfig = clientConfig.Clone()
+				clientConfig.VerifyConnection = c.verifyConnection(clientConfig.VerifyConnection)
+			}
+
+			return clientConfig, err
+		}
+	}
+
+	return config
+}
+
 // conn is a decorated net.Conn that supplies feedback to a listener when the connection is closed.
 type conn struct {
 	net.Conn
 	releaseOnce sync.Once
 	release     func()
+
+	// handshaking and rejected are only set for TLS connections, so that failed handshakes can be counted
+	handshaking  func() bool
+	rejected     rejecter
+	rejectedOnce sync.Once
+
+	// layered, attempted, and verified track a TLS handshake layered over this connection.  The handshake
+	// is attempted once the connection is first read, and verified once it succeeds.
+	layered   bool
+	attempted int32
+	verified  int32
+}
+
+// verifyConnection decorates a tls.Config.VerifyConnection callback so that this connection is
+// marked as verified when the callback succeeds.  The decorated callback may be nil.
+func (c *conn) verifyConnection(next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if next != nil {
+			if err := next(cs); err != nil {
+				return err
+			}
+		}
+
+		atomic.StoreInt32(&c.verified, 1)
+		return nil
+	}
+}
+
+// awaitingVerification tests if the TLS handshake layered over this connection has yet to succeed.
+// Unlike tls.Conn.ConnectionState, this is safe to call while the handshake is in progress.
+func (c *conn) awaitingVerification() bool {
+	return atomic.LoadInt32(&c.verified) == 0
+}
+
+// Read reads from the decorated connection.  For TLS connections, an error that occurs before
+// the handshake completes is counted as a rejected connection.
+func (c *conn) Read(b []byte) (int, error) {
+	atomic.StoreInt32(&c.attempted, 1)
+	n, err := c.Conn.Read(b)
+	if err != nil && c.handshaking != nil && c.handshaking() {
+		c.reject()
+	}
+
+	return n, err
+}
+
+// reject counts this connection as having failed its TLS handshake.  A connection is counted at most once.
+func (c *conn) reject() {
+	c.rejectedOnce.Do(func() {
+		c.rejected(TLSHandshakeReason)
+	})
 }
 
 // Close closes the decorated connection and invokes release on the listener that created it.  The release
 // operation is idempotent.
+//
+// A TLS handshake layered over this connection that was attempted but never verified, such as one that
+// received a malformed record, is counted as a rejected connection.
 func (c *conn) Close() error {
+	if c.layered && atomic.LoadInt32(&c.attempted) == 1 && c.awaitingVerification() {
+		c.reject()
+	}
+
 	err := c.Conn.Close()
 	c.releaseOnce.Do(c.release)
 	return err
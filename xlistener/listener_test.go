@@ -1,17 +1,36 @@
 package xlistener
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
+	"io"
+	"math/big"
 	"net"
 	"testing"
+	"time"
 
+	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/generic"
+	gokitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/sallust"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest"
 )
 
+// rejectedValue returns the value of the rejected counter for the given reason
+func rejectedValue(c metrics.Counter, reason string) float64 {
+	return c.With(RejectedReasonLabel, reason).(xmetrics.Valuer).Value()
+}
+
 func testNewDefault(t *testing.T) {
 	defer func() { netListen = net.Listen }()
 
@@ -51,7 +70,7 @@ func testNewCustom(t *testing.T) {
 		assert  = assert.New(t)
 		require = require.New(t)
 
-		expectedRejected = generic.NewCounter("test")
+		expectedRejected = xmetricstest.NewCounter("test")
 		expectedActive   = generic.NewGauge("test")
 		expectedNext     = new(mockListener)
 	)
@@ -68,6 +87,7 @@ func testNewCustom(t *testing.T) {
 	l, err := New(Options{
 		Logger:         sallust.Default(),
 		Rejected:       expectedRejected,
+		LabelRejected:  true,
 		Active:         expectedActive,
 		Network:        "tcp4",
 		Address:        ":8080",
@@ -83,7 +103,7 @@ func testNewCustom(t *testing.T) {
 
 	require.NotNil(l.(*listener).rejected)
 	l.(*listener).rejected.Inc()
-	assert.Equal(1.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, UnknownReason))
 
 	require.NotNil(l.(*listener).active)
 	l.(*listener).active.Add(10.0)
@@ -100,7 +120,7 @@ func testNewTLSCustom(t *testing.T) {
 		assert  = assert.New(t)
 		require = require.New(t)
 
-		expectedRejected = generic.NewCounter("test")
+		expectedRejected = xmetricstest.NewCounter("test")
 		expectedActive   = generic.NewGauge("test")
 		expectedNext     = new(mockListener)
 	)
@@ -108,16 +128,16 @@ func testNewTLSCustom(t *testing.T) {
 	// nolint: typecheck
 	expectedNext.On("Addr").Return(new(net.IPAddr)).Twice()
 
-	tlsListen = func(network, address string, config *tls.Config) (net.Listener, error) {
+	netListen = func(network, address string) (net.Listener, error) {
 		assert.Equal("tcp4", network)
 		assert.Equal(":8080", address)
-		assert.Equal(true, config.InsecureSkipVerify)
 		return expectedNext, nil
 	}
 
 	l, err := New(Options{
 		Logger:         sallust.Default(),
 		Rejected:       expectedRejected,
+		LabelRejected:  true,
 		Active:         expectedActive,
 		Network:        "tcp4",
 		Address:        ":8080",
@@ -133,10 +153,12 @@ func testNewTLSCustom(t *testing.T) {
 	assert.Equal(expectedNext, l.(*listener).Listener)
 	assert.NotNil(l.(*listener).logger)
 	assert.NotNil(l.(*listener).semaphore)
+	require.NotNil(l.(*listener).config)
+	assert.True(l.(*listener).config.InsecureSkipVerify)
 
 	require.NotNil(l.(*listener).rejected)
 	l.(*listener).rejected.Inc()
-	assert.Equal(1.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, UnknownReason))
 
 	require.NotNil(l.(*listener).active)
 	l.(*listener).active.Add(10.0)
@@ -179,7 +201,7 @@ func testListenerAcceptError(t *testing.T, maxConnections int) {
 		assert  = assert.New(t)
 		require = require.New(t)
 
-		expectedRejected = generic.NewCounter("test")
+		expectedRejected = xmetricstest.NewCounter("test")
 		expectedActive   = generic.NewGauge("test")
 		expectedError    = errors.New("expected")
 		expectedNext     = new(mockListener)
@@ -194,6 +216,7 @@ func testListenerAcceptError(t *testing.T, maxConnections int) {
 		Logger:         sallust.Default(),
 		MaxConnections: maxConnections,
 		Rejected:       expectedRejected,
+		LabelRejected:  true,
 		Active:         expectedActive,
 		Next:           expectedNext,
 	})
@@ -204,7 +227,8 @@ func testListenerAcceptError(t *testing.T, maxConnections int) {
 	c, actualError := l.Accept()
 	assert.Nil(c)
 	assert.Equal(expectedError, actualError)
-	assert.Equal(0.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, AcceptErrorReason))
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Equal(0.0, expectedActive.Value())
 
 	// nolint: typecheck
@@ -218,7 +242,7 @@ func testListenerAcceptUnlimitedConnections(t *testing.T) {
 		assert  = assert.New(t)
 		require = require.New(t)
 
-		expectedRejected = generic.NewCounter("test")
+		expectedRejected = xmetricstest.NewCounter("test")
 		expectedActive   = generic.NewGauge("test")
 		expectedNext     = new(mockListener)
 
@@ -249,44 +273,45 @@ func testListenerAcceptUnlimitedConnections(t *testing.T) {
 	expectedConn2.On("Close").Return(expectedConnCloseError).Once()
 
 	l, err := New(Options{
-		Logger:   sallust.Default(),
-		Rejected: expectedRejected,
-		Active:   expectedActive,
-		Next:     expectedNext,
+		Logger:        sallust.Default(),
+		Rejected:      expectedRejected,
+		LabelRejected: true,
+		Active:        expectedActive,
+		Next:          expectedNext,
 	})
 
 	require.NoError(err)
 	require.NotNil(l)
 
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Zero(expectedActive.Value())
 
 	actualConn1, actualError := l.Accept()
 	assert.NoError(actualError)
 	require.NotNil(actualConn1)
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Equal(1.0, expectedActive.Value())
 
 	actualConn2, actualError := l.Accept()
 	assert.NoError(actualError)
 	require.NotNil(actualConn2)
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Equal(2.0, expectedActive.Value())
 
 	assert.NoError(actualConn1.Close())
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Equal(1.0, expectedActive.Value())
 
 	assert.Equal(expectedConnCloseError, actualConn1.Close())
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Equal(1.0, expectedActive.Value())
 
 	assert.NoError(actualConn2.Close())
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Zero(expectedActive.Value())
 
 	assert.Equal(expectedConnCloseError, actualConn2.Close())
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Zero(expectedActive.Value())
 
 	// nolint: typecheck
@@ -304,7 +329,7 @@ func testListenerAcceptMaxConnections(t *testing.T) {
 		assert  = assert.New(t)
 		require = require.New(t)
 
-		expectedRejected = generic.NewCounter("test")
+		expectedRejected = xmetricstest.NewCounter("test")
 		expectedActive   = generic.NewGauge("test")
 		expectedNext     = new(mockListener)
 
@@ -348,6 +373,7 @@ func testListenerAcceptMaxConnections(t *testing.T) {
 		Logger:         sallust.Default(),
 		MaxConnections: 1,
 		Rejected:       expectedRejected,
+		LabelRejected:  true,
 		Active:         expectedActive,
 		Next:           expectedNext,
 	})
@@ -355,42 +381,43 @@ func testListenerAcceptMaxConnections(t *testing.T) {
 	require.NoError(err)
 	require.NotNil(l)
 
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Zero(expectedActive.Value())
 
 	actualConn1, actualError := l.Accept()
 	assert.NoError(actualError)
 	require.NotNil(actualConn1)
-	assert.Zero(expectedRejected.Value())
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Equal(1.0, expectedActive.Value())
 
 	actualRejectedConn, actualError := l.Accept()
 	assert.Equal(expectedAcceptError, actualError)
 	assert.Nil(actualRejectedConn)
-	assert.Equal(1.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, AcceptErrorReason))
+	assert.Equal(1.0, rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Equal(1.0, expectedActive.Value())
 
 	assert.NoError(actualConn1.Close())
-	assert.Equal(1.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Zero(expectedActive.Value())
 
 	assert.Equal(expectedConnCloseError, actualConn1.Close())
-	assert.Equal(1.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Zero(expectedActive.Value())
 
 	// now, a new connection should be possible
 	actualConn2, actualError := l.Accept()
 	assert.NoError(actualError)
 	require.NotNil(actualConn2)
-	assert.Equal(1.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Equal(1.0, expectedActive.Value())
 
 	assert.NoError(actualConn2.Close())
-	assert.Equal(1.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Zero(expectedActive.Value())
 
 	assert.Equal(expectedConnCloseError, actualConn2.Close())
-	assert.Equal(1.0, expectedRejected.Value())
+	assert.Equal(1.0, rejectedValue(expectedRejected, MaxConnectionsReason))
 	assert.Zero(expectedActive.Value())
 
 	// nolint: typecheck
@@ -403,6 +430,414 @@ func testListenerAcceptMaxConnections(t *testing.T) {
 	expectedConn2.AssertExpectations(t)
 }
 
+func newDrainTestListener(t *testing.T, active *generic.Gauge) net.Listener {
+	next, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l, err := New(Options{
+		Logger: sallust.Default(),
+		Active: active,
+		Next:   next,
+	})
+
+	require.NoError(t, err)
+	require.NotNil(t, l)
+	return l
+}
+
+// acceptN dials and accepts the given number of connections, returning the server side of each
+func acceptN(t *testing.T, l net.Listener, n int) []net.Conn {
+	var accepted []net.Conn
+	for i := 0; i < n; i++ {
+		client, err := net.Dial(l.Addr().Network(), l.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+
+		c, err := l.Accept()
+		require.NoError(t, err)
+		require.NotNil(t, c)
+		accepted = append(accepted, c)
+	}
+
+	return accepted
+}
+
+func testListenerDrainNoConnections(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		l       = newDrainTestListener(t, generic.NewGauge("test"))
+	)
+
+	require.Implements((*Drainer)(nil), l)
+	assert.NoError(l.(Drainer).Drain(context.Background()))
+
+	c, err := l.Accept()
+	assert.Error(err)
+	assert.Nil(c)
+
+	// closing a drained listener is harmless
+	assert.NoError(l.Close())
+}
+
+func testListenerDrainWaits(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		require  = require.New(t)
+		active   = generic.NewGauge("test")
+		l        = newDrainTestListener(t, active)
+		accepted = acceptN(t, l, 2)
+		drained  = make(chan error, 1)
+	)
+
+	require.Equal(2.0, active.Value())
+	go func() {
+		drained <- l.(Drainer).Drain(context.Background())
+	}()
+
+	// no new connections are accepted while draining
+	assert.Eventually(
+		func() bool {
+			c, err := net.Dial(l.Addr().Network(), l.Addr().String())
+			if err == nil {
+				c.Close()
+			}
+
+			return err != nil
+		},
+		time.Second,
+		10*time.Millisecond,
+	)
+
+	c, err := l.Accept()
+	assert.Error(err)
+	assert.Nil(c)
+
+	assert.NoError(accepted[0].Close())
+	select {
+	case err := <-drained:
+		assert.Fail("Drain returned before all connections were closed", "error: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Equal(1.0, active.Value())
+	assert.NoError(accepted[1].Close())
+
+	select {
+	case err := <-drained:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("Drain did not return after all connections were closed")
+	}
+
+	assert.Zero(active.Value())
+}
+
+func testListenerDrainContextDone(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		active   = generic.NewGauge("test")
+		l        = newDrainTestListener(t, active)
+		accepted = acceptN(t, l, 1)
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(context.DeadlineExceeded, l.(Drainer).Drain(ctx))
+	assert.Equal(1.0, active.Value())
+
+	assert.NoError(accepted[0].Close())
+	assert.Zero(active.Value())
+}
+
+func testListenerAcceptUnlabeled(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		// a gauge is an xmetrics.Adder that isn't a metrics.Counter, so no labels are used
+		expectedRejected = generic.NewGauge("test")
+		expectedNext     = new(mockListener)
+		rejectedConn     = new(mockConn)
+		expectedError    = errors.New("expected")
+	)
+
+	// nolint: typecheck
+	expectedNext.On("Addr").Return(new(net.IPAddr)).Twice()
+	// nolint: typecheck
+	expectedNext.On("Accept").Return(rejectedConn, error(nil)).Once()
+	// nolint: typecheck
+	expectedNext.On("Accept").Return(nil, expectedError).Once()
+	// nolint: typecheck
+	rejectedConn.On("RemoteAddr").Return(new(net.IPAddr)).Once()
+	// nolint: typecheck
+	rejectedConn.On("Close").Return(error(nil)).Once()
+
+	l, err := New(Options{
+		Logger:         sallust.Default(),
+		MaxConnections: 1,
+		Rejected:       expectedRejected,
+		Next:           expectedNext,
+	})
+
+	require.NoError(err)
+	require.NotNil(l)
+
+	// fill the semaphore, so that the next connection is rejected
+	require.True(l.(*listener).acquire())
+
+	c, actualError := l.Accept()
+	assert.Nil(c)
+	assert.Equal(expectedError, actualError)
+	assert.Equal(2.0, expectedRejected.Value())
+
+	// nolint: typecheck
+	expectedNext.AssertExpectations(t)
+	// nolint: typecheck
+	rejectedConn.AssertExpectations(t)
+}
+
+func testListenerAcceptUnlabeledPrometheus(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+
+		// a prometheus counter that declares no labels panics if labels are applied
+		counterVec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{})
+		gatherer   = prometheus.NewRegistry()
+
+		expectedNext  = new(mockListener)
+		rejectedConn  = new(mockConn)
+		expectedError = errors.New("expected")
+	)
+
+	require.NoError(gatherer.Register(counterVec))
+
+	// nolint: typecheck
+	expectedNext.On("Addr").Return(new(net.IPAddr)).Twice()
+	// nolint: typecheck
+	expectedNext.On("Accept").Return(rejectedConn, error(nil)).Once()
+	// nolint: typecheck
+	expectedNext.On("Accept").Return(nil, expectedError).Once()
+	// nolint: typecheck
+	rejectedConn.On("RemoteAddr").Return(new(net.IPAddr)).Once()
+	// nolint: typecheck
+	rejectedConn.On("Close").Return(error(nil)).Once()
+
+	l, err := New(Options{
+		Logger:         sallust.Default(),
+		MaxConnections: 1,
+		Rejected:       gokitprometheus.NewCounter(counterVec),
+		Next:           expectedNext,
+	})
+
+	require.NoError(err)
+	require.NotNil(l)
+
+	// fill the semaphore, so that the next connection is rejected
+	require.True(l.(*listener).acquire())
+
+	var (
+		c           net.Conn
+		actualError error
+	)
+
+	require.NotPanics(func() { c, actualError = l.Accept() })
+	assert.Nil(c)
+	assert.Equal(expectedError, actualError)
+
+	families, err := gatherer.Gather()
+	require.NoError(err)
+	require.Len(families, 1)
+	require.Len(families[0].GetMetric(), 1)
+	assert.Equal(2.0, families[0].GetMetric()[0].GetCounter().GetValue())
+
+	// nolint: typecheck
+	expectedNext.AssertExpectations(t)
+	// nolint: typecheck
+	rejectedConn.AssertExpectations(t)
+}
+
+func testListenerTLSHandshakeRejected(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		expectedRejected = xmetricstest.NewCounter("test")
+	)
+
+	next, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	l, err := New(Options{
+		Logger:        sallust.Default(),
+		Rejected:      expectedRejected,
+		LabelRejected: true,
+		Next:          tls.NewListener(next, new(tls.Config)),
+	})
+
+	require.NoError(err)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", next.Addr().String())
+	require.NoError(err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("this is not a TLS handshake\r\n"))
+	require.NoError(err)
+
+	c, err := l.Accept()
+	require.NoError(err)
+	defer c.Close()
+
+	_, err = c.Read(make([]byte, 10))
+	assert.Error(err)
+	_, err = c.Read(make([]byte, 10))
+	assert.Error(err)
+
+	assert.Equal(1.0, rejectedValue(expectedRejected, TLSHandshakeReason))
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
+}
+
+// newTestCertificate generates a self-signed certificate for 127.0.0.1
+func newTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "xlistener test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func testListenerTLSConfigHandshakeRejected(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		expectedRejected = xmetricstest.NewCounter("test")
+	)
+
+	next, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	l, err := New(Options{
+		Logger:        sallust.Default(),
+		Rejected:      expectedRejected,
+		LabelRejected: true,
+		Next:          next,
+		Config:        &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}},
+	})
+
+	require.NoError(err)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", next.Addr().String())
+	require.NoError(err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("this is not a TLS handshake\r\n"))
+	require.NoError(err)
+
+	c, err := l.Accept()
+	require.NoError(err)
+	defer c.Close()
+
+	tc, ok := c.(*tls.Conn)
+	require.True(ok)
+	assert.Error(tc.Handshake())
+
+	_, err = c.Read(make([]byte, 10))
+	assert.Error(err)
+
+	assert.NoError(c.Close())
+	c.Close()
+	assert.Equal(1.0, rejectedValue(expectedRejected, TLSHandshakeReason))
+	assert.Zero(rejectedValue(expectedRejected, MaxConnectionsReason))
+}
+
+func testListenerTLSConfigHandshakeComplete(t *testing.T) {
+	var (
+		assert           = assert.New(t)
+		require          = require.New(t)
+		expectedRejected = xmetricstest.NewCounter("test")
+		expectedActive   = generic.NewGauge("test")
+		verified         = make(chan struct{}, 1)
+	)
+
+	next, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	l, err := New(Options{
+		Logger:        sallust.Default(),
+		Rejected:      expectedRejected,
+		LabelRejected: true,
+		Active:        expectedActive,
+		Next:          next,
+		Config: &tls.Config{
+			Certificates: []tls.Certificate{newTestCertificate(t)},
+			NextProtos:   []string{"h2", "http/1.1"},
+			VerifyConnection: func(tls.ConnectionState) error {
+				verified <- struct{}{}
+				return nil
+			},
+		},
+	})
+
+	require.NoError(err)
+	defer l.Close()
+
+	clientResult := make(chan error, 1)
+	go func() {
+		client, err := tls.Dial("tcp", next.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true, // nolint: gosec
+			NextProtos:         []string{"h2"},
+		})
+
+		if err == nil {
+			_, err = client.Write([]byte("hello"))
+			client.Close()
+		}
+
+		clientResult <- err
+	}()
+
+	c, err := l.Accept()
+	require.NoError(err)
+
+	tc, ok := c.(*tls.Conn)
+	require.True(ok)
+	require.NoError(tc.Handshake())
+	assert.Equal("h2", tc.ConnectionState().NegotiatedProtocol)
+	assert.Len(verified, 1)
+
+	buffer := make([]byte, 5)
+	_, err = io.ReadFull(c, buffer)
+	require.NoError(err)
+	assert.Equal("hello", string(buffer))
+	require.NoError(<-clientResult)
+
+	_, err = c.Read(buffer)
+	assert.Error(err)
+	assert.Zero(rejectedValue(expectedRejected, TLSHandshakeReason))
+
+	assert.Equal(1.0, expectedActive.Value())
+	c.Close()
+	assert.Zero(expectedActive.Value())
+}
+
 func TestListener(t *testing.T) {
 	t.Run("Accept", func(t *testing.T) {
 		t.Run("Error", func(t *testing.T) {
@@ -414,5 +849,19 @@ func TestListener(t *testing.T) {
 			t.Run("UnlimitedConnections", testListenerAcceptUnlimitedConnections)
 			t.Run("MaxConnections", testListenerAcceptMaxConnections)
 		})
+
+		t.Run("Unlabeled", testListenerAcceptUnlabeled)
+		t.Run("UnlabeledPrometheus", testListenerAcceptUnlabeledPrometheus)
+		t.Run("TLSHandshake", testListenerTLSHandshakeRejected)
+		t.Run("TLSConfig", func(t *testing.T) {
+			t.Run("HandshakeRejected", testListenerTLSConfigHandshakeRejected)
+			t.Run("HandshakeComplete", testListenerTLSConfigHandshakeComplete)
+		})
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		t.Run("NoConnections", testListenerDrainNoConnections)
+		t.Run("Waits", testListenerDrainWaits)
+		t.Run("ContextDone", testListenerDrainContextDone)
 	})
 }